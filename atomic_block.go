@@ -0,0 +1,114 @@
+package cidrx
+
+import (
+	"math/bits"
+	"net"
+	"sync/atomic"
+)
+
+// atomicBlock is the lock-free counterpart of block: bits are claimed and cleared with compare-and-swap on the bitmap
+// words, so any number of goroutines can allocate from and release to the same block without a mutex. Only creating
+// blocks and keeping track of which ones have free bits needs to be synchronized by the owner, see AtomicPool.
+//
+// Allocation is two-phase: a goroutine first reserves one unit of freeCount, which guarantees a clear bit exists for
+// it, and then searches the words for one it can set. A clear bit while freeCount is zero is therefore already promised
+// to an in-flight allocBit.
+type atomicBlock struct {
+	base      Uint128         // first address of the block
+	used      []atomic.Uint64 // bitmap words: 1 means allocated
+	freeCount atomic.Int64    // free bits not yet reserved by an allocBit
+	hint      atomic.Uint64   // word index where the next search starts
+	size      uint64          // total bits
+	// listed is set while the block sits in the free list of its AtomicPool
+	listed atomic.Bool
+}
+
+// newAtomicBlock creates an atomicBlock starting at base with size bits.
+func newAtomicBlock(base Uint128, size uint64) *atomicBlock {
+	b := &atomicBlock{
+		base: base,
+		used: make([]atomic.Uint64, (size+63)/64),
+		size: size,
+	}
+	// Mark the bits past size in the last word as allocated so the search never has to bound-check them
+	if tail := size % 64; tail != 0 {
+		b.used[len(b.used)-1].Store(^uint64(0) << tail)
+	}
+	b.freeCount.Store(int64(size)) //nolint:gosec // block sizes are at most 2^63
+	return b
+}
+
+// allocBit reserves and sets a zero bit, returning its index
+func (b *atomicBlock) allocBit() (uint64, error) {
+	if !b.reserve() {
+		return 0, ErrBlockFull
+	}
+
+	// A bit is guaranteed to be clear for this goroutine, keep scanning the words until the CAS wins it
+	start := b.hint.Load()
+	n := uint64(len(b.used))
+	for i := uint64(0); ; i++ {
+		wi := (start + i) % n
+		for {
+			word := b.used[wi].Load()
+			if ^word == 0 {
+				break
+			}
+			bit := bits.TrailingZeros64(^word)
+			if b.used[wi].CompareAndSwap(word, word|1<<bit) {
+				b.hint.Store(wi)
+				return wi*64 + uint64(bit), nil
+			}
+		}
+	}
+}
+
+// releaseBit clears the bit at idx and reports whether the block had no free bit left before
+func (b *atomicBlock) releaseBit(idx uint64) (bool, error) {
+	if idx >= b.size {
+		return false, ErrOutOfRange
+	}
+
+	wi := idx / 64
+	mask := uint64(1) << (idx % 64)
+	for {
+		word := b.used[wi].Load()
+		if word&mask == 0 {
+			return false, ErrNotAllocated
+		}
+		if b.used[wi].CompareAndSwap(word, word&^mask) {
+			break
+		}
+	}
+
+	// Publish the bit only once it is clear, so whoever reserves it is sure to find it
+	return b.freeCount.Add(1) == 1, nil
+}
+
+// isSet reports whether the bit at idx is allocated
+func (b *atomicBlock) isSet(idx uint64) bool {
+	return idx < b.size && b.used[idx/64].Load()&(1<<(idx%64)) != 0
+}
+
+// free reports how many bits can still be allocated
+func (b *atomicBlock) free() uint64 {
+	return uint64(b.freeCount.Load()) //nolint:gosec // never negative, reserve stops at zero
+}
+
+// bitToIP converts a bit index into an IP within this block
+func (b *atomicBlock) bitToIP(idx uint64) net.IP {
+	return b.base.add(Uint128{Lo: idx}).toIP()
+}
+
+// reserve takes one unit of freeCount, failing if none is left
+func (b *atomicBlock) reserve() bool {
+	for {
+		n := b.freeCount.Load()
+		if n <= 0 {
+			return false
+		}
+		if b.freeCount.CompareAndSwap(n, n-1) {
+			return true
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package cidrx //nolint:testpackage // it's OK to be just cidrx
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// TestAtomicBlockAllocRelease ensures an atomicBlock hands out every bit once and reuses released ones
+func TestAtomicBlockAllocRelease(t *testing.T) {
+	// 70 bits span two words, the second one partially
+	b := newAtomicBlock(Uint128{Hi: 0x20010db800000000}, 70)
+	seen := make(map[uint64]bool)
+	for i := 0; i < 70; i++ {
+		idx, err := b.allocBit()
+		if err != nil {
+			t.Fatalf("allocBit #%d error: %v", i, err)
+		}
+		if idx >= 70 || seen[idx] {
+			t.Fatalf("allocBit #%d returned %d; want a new index below 70", i, idx)
+		}
+		seen[idx] = true
+	}
+	if _, err := b.allocBit(); !errors.Is(err, ErrBlockFull) {
+		t.Errorf("allocBit on full block err = %v; want ErrBlockFull", err)
+	}
+
+	if wasFull, err := b.releaseBit(66); err != nil || !wasFull {
+		t.Fatalf("releaseBit = %v, %v; want true, nil", wasFull, err)
+	}
+	if _, err := b.releaseBit(66); !errors.Is(err, ErrNotAllocated) {
+		t.Errorf("second releaseBit err = %v; want ErrNotAllocated", err)
+	}
+	if idx, err := b.allocBit(); err != nil || idx != 66 {
+		t.Errorf("allocBit after release = %d, %v; want 66", idx, err)
+	}
+	if _, err := b.releaseBit(70); !errors.Is(err, ErrOutOfRange) {
+		t.Errorf("releaseBit past size err = %v; want ErrOutOfRange", err)
+	}
+}
+
+// TestAtomicBlockConcurrent ensures concurrent allocators never share a bit and the block drains to exactly full
+func TestAtomicBlockConcurrent(t *testing.T) {
+	const size, goroutines = 1000, 8
+	b := newAtomicBlock(Uint128{}, size)
+	owners := make([]atomic.Int32, size)
+
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Churn: take a bit, check nobody else holds it, hand it back
+			for i := 0; i < 2000; i++ {
+				idx, err := b.allocBit()
+				if errors.Is(err, ErrBlockFull) {
+					continue
+				}
+				if !owners[idx].CompareAndSwap(0, 1) {
+					t.Errorf("bit %d handed out twice", idx)
+					return
+				}
+				owners[idx].Store(0)
+				if _, errRelease := b.releaseBit(idx); errRelease != nil {
+					t.Errorf("releaseBit error: %v", errRelease)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < size; i++ {
+		if _, err := b.allocBit(); err != nil {
+			t.Fatalf("allocBit %d after churn error: %v", i, err)
+		}
+	}
+	if _, err := b.allocBit(); !errors.Is(err, ErrBlockFull) {
+		t.Errorf("allocBit past size err = %v; want ErrBlockFull", err)
+	}
+}
@@ -0,0 +1,183 @@
+package cidrx
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// AtomicPool hands out addresses like a Pool, but claims and clears bits with compare-and-swap instead of holding a
+// mutex, so any number of goroutines can allocate from and release to the same block at once. Only creating a block
+// and listing a full block that got addresses back take a lock. In exchange it has none of the features built on the
+// Pool lock: options, journaling, leases, snapshots and the batch and contiguous APIs.
+type AtomicPool struct {
+	networkAddr Uint128
+	hostBits    uint
+	blockSize   uint64
+	maxBlocks   uint64
+	ipv4        bool
+
+	// current is the block Allocate takes bits from, replaced under mu once it is full
+	current atomic.Pointer[atomicBlock]
+	// blocks maps the index of every created block to its *atomicBlock, entries are only added under mu
+	blocks sync.Map
+
+	// mu guards the slow path: the free list and block creation
+	mu sync.Mutex
+	// full blocks that got bits back since, in release order. A block is only listed once, see atomicBlock.listed
+	freeList       []*atomicBlock
+	nextBlockIndex uint64
+}
+
+// NewAtomicPool constructs an AtomicPool over the given IPv6 or IPv4 network. The arguments match NewPool.
+func NewAtomicPool(netAddress string, netPrefixLen, blockPrefix, expectedBlocks int) (*AtomicPool, error) {
+	// Validate the network through NewPool, its configuration is then copied over
+	cfg, err := NewPool(netAddress, netPrefixLen, blockPrefix, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AtomicPool{
+		networkAddr: cfg.networkAddr,
+		hostBits:    cfg.hostBits,
+		blockSize:   cfg.blockSize,
+		maxBlocks:   cfg.maxBlocks,
+		ipv4:        cfg.ipv4,
+		freeList:    make([]*atomicBlock, 0, expectedBlocks),
+	}, nil
+}
+
+// Allocate returns a free IP from the pool, in its 4-byte form for IPv4 pools. It only locks when the current block
+// is full.
+func (ap *AtomicPool) Allocate() (net.IP, error) {
+	b := ap.current.Load()
+	for {
+		if b != nil {
+			if idx, err := b.allocBit(); err == nil {
+				return ap.outIP(b.bitToIP(idx)), nil
+			}
+		}
+
+		var err error
+		if b, err = ap.nextBlock(b); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Release frees an IP back to the pool. Fails with an *AddressError wrapping ErrNotInPool if ip lies outside the pool
+// network and ErrNotAllocated if it is not allocated.
+func (ap *AtomicPool) Release(ip net.IP) error {
+	if ip.To16() == nil || (ip.To4() != nil) != ap.ipv4 {
+		return notInPool("release", ip)
+	}
+
+	u := fromIP(ip)
+	bi, ok := ap.blockOf(u)
+	if !ok {
+		return notInPool("release", ip)
+	}
+	b, ok := ap.block(bi)
+	if !ok {
+		return ap.addressError("release", u, bi, ErrNotAllocated)
+	}
+
+	wasFull, err := b.releaseBit(u.sub(b.base).Lo)
+	if err != nil {
+		return ap.addressError("release", u, bi, err)
+	}
+	// The block went out of rotation once it filled up, list it again so Allocate finds the bit
+	if wasFull && b.listed.CompareAndSwap(false, true) {
+		ap.mu.Lock()
+		ap.freeList = append(ap.freeList, b)
+		ap.mu.Unlock()
+	}
+	return nil
+}
+
+// IsAllocated reports whether ip is currently allocated.
+func (ap *AtomicPool) IsAllocated(ip net.IP) bool {
+	if ip.To16() == nil || (ip.To4() != nil) != ap.ipv4 {
+		return false
+	}
+
+	u := fromIP(ip)
+	bi, ok := ap.blockOf(u)
+	if !ok {
+		return false
+	}
+	b, ok := ap.block(bi)
+	return ok && b.isSet(u.sub(b.base).Lo)
+}
+
+// nextBlock replaces full as the current block with a listed block that has free bits, or a new one. If another
+// goroutine replaced it already, its choice is returned instead.
+func (ap *AtomicPool) nextBlock(full *atomicBlock) (*atomicBlock, error) {
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+
+	if cur := ap.current.Load(); cur != full && cur.free() > 0 {
+		return cur, nil
+	}
+
+	for len(ap.freeList) > 0 {
+		b := ap.freeList[0]
+		ap.freeList = ap.freeList[1:]
+		// Unlist before looking at the count: a release racing with the check either shows up in it or lists the
+		// block again
+		b.listed.Store(false)
+		if b.free() > 0 {
+			ap.current.Store(b)
+			return b, nil
+		}
+	}
+
+	if ap.nextBlockIndex >= ap.maxBlocks {
+		return nil, ErrPoolExhausted
+	}
+	bi := ap.nextBlockIndex
+	b := newAtomicBlock(ap.networkAddr.add(Uint128{Lo: bi}.lsh(ap.hostBits)), ap.blockSize)
+	ap.blocks.Store(bi, b)
+	ap.nextBlockIndex++
+	ap.current.Store(b)
+	return b, nil
+}
+
+// block returns the block with index bi, if it was created
+func (ap *AtomicPool) block(bi uint64) (*atomicBlock, bool) {
+	v, ok := ap.blocks.Load(bi)
+	if !ok {
+		return nil, false
+	}
+	b, ok := v.(*atomicBlock)
+	return b, ok
+}
+
+// blockOf returns the index of the block u falls in, or false if u lies outside the network
+func (ap *AtomicPool) blockOf(u Uint128) (uint64, bool) {
+	if u.cmp(ap.networkAddr) < 0 {
+		return 0, false
+	}
+	bi := u.sub(ap.networkAddr).rsh(ap.hostBits)
+	if bi.Hi != 0 || bi.Lo >= ap.maxBlocks {
+		return 0, false
+	}
+	return bi.Lo, true
+}
+
+// addressError wraps err in an AddressError for op on u, which falls in block bi
+func (ap *AtomicPool) addressError(op string, u Uint128, bi uint64, err error) error {
+	addr := u.toAddr()
+	if ap.ipv4 {
+		addr = addr.Unmap()
+	}
+	return &AddressError{Op: op, Addr: addr, Block: bi, Err: err}
+}
+
+// outIP returns ip as handed to callers: 4 bytes long for IPv4 pools
+func (ap *AtomicPool) outIP(ip net.IP) net.IP {
+	if ap.ipv4 {
+		return ip.To4()
+	}
+	return ip
+}
@@ -0,0 +1,133 @@
+package cidrx //nolint:testpackage // it's OK to be just cidrx
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"testing"
+)
+
+// TestAtomicPoolAllocateRelease ensures an AtomicPool hands out every address once, reports exhaustion and reuses
+// addresses released from full blocks
+func TestAtomicPoolAllocateRelease(t *testing.T) {
+	// Four /126 blocks of 4 addresses
+	pool, err := NewAtomicPool("2001:db8::", 124, 126, 1)
+	if err != nil {
+		t.Fatalf("NewAtomicPool error: %v", err)
+	}
+
+	seen := make(map[string]bool)
+	for i := 0; i < 16; i++ {
+		ip, errAllocate := pool.Allocate()
+		if errAllocate != nil {
+			t.Fatalf("Allocate #%d error: %v", i, errAllocate)
+		}
+		if seen[ip.String()] {
+			t.Fatalf("Allocate #%d returned %v twice", i, ip)
+		}
+		seen[ip.String()] = true
+	}
+	if _, errAllocate := pool.Allocate(); !errors.Is(errAllocate, ErrPoolExhausted) {
+		t.Errorf("Allocate on full pool err = %v; want ErrPoolExhausted", errAllocate)
+	}
+
+	ip := net.ParseIP("2001:db8::5")
+	if errRelease := pool.Release(ip); errRelease != nil {
+		t.Fatalf("Release error: %v", errRelease)
+	}
+	if pool.IsAllocated(ip) {
+		t.Errorf("IsAllocated(%v) after Release = true", ip)
+	}
+	if got, errAllocate := pool.Allocate(); errAllocate != nil || !got.Equal(ip) {
+		t.Errorf("Allocate after Release = %v, %v; want %v", got, errAllocate, ip)
+	}
+
+	var addrErr *AddressError
+	if errRelease := pool.Release(net.ParseIP("2001:db8::1:0")); !errors.Is(errRelease, ErrNotInPool) {
+		t.Errorf("Release outside the network err = %v; want ErrNotInPool", errRelease)
+	}
+	_ = pool.Release(ip)
+	if errRelease := pool.Release(ip); !errors.As(errRelease, &addrErr) || !errors.Is(errRelease, ErrNotAllocated) ||
+		addrErr.Block != 1 {
+		t.Errorf("second Release err = %v; want ErrNotAllocated in block 1", errRelease)
+	}
+}
+
+// TestAtomicPoolIPv4 ensures IPv4 pools hand out and take back 4-byte addresses
+func TestAtomicPoolIPv4(t *testing.T) {
+	pool, err := NewAtomicPool("10.0.0.0", 24, 28, 1)
+	if err != nil {
+		t.Fatalf("NewAtomicPool error: %v", err)
+	}
+	ip, err := pool.Allocate()
+	if err != nil || len(ip) != net.IPv4len || !ip.Equal(net.ParseIP("10.0.0.0")) {
+		t.Fatalf("Allocate = %v, %v; want 4-byte 10.0.0.0", ip, err)
+	}
+	if errRelease := pool.Release(net.ParseIP("2001:db8::")); !errors.Is(errRelease, ErrNotInPool) {
+		t.Errorf("Release of an IPv6 address err = %v; want ErrNotInPool", errRelease)
+	}
+	if errRelease := pool.Release(ip); errRelease != nil {
+		t.Errorf("Release error: %v", errRelease)
+	}
+}
+
+// TestAtomicPoolConcurrent ensures concurrent allocators never share an address, even while blocks fill up, get
+// addresses back and new ones are created
+func TestAtomicPoolConcurrent(t *testing.T) {
+	const goroutines, rounds = 8, 2000
+	// 16 /124 blocks, fewer addresses than goroutines hold at their peak so exhaustion is hit too
+	pool, _ := NewAtomicPool("2001:db8::", 120, 124, 1)
+
+	var owners sync.Map
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			held := make([]net.IP, 0, 64)
+			for i := 0; i < rounds; i++ {
+				if i%3 == 2 && len(held) > 0 {
+					ip := held[0]
+					held = held[1:]
+					owners.Delete(ip.String())
+					if err := pool.Release(ip); err != nil {
+						t.Errorf("Release error: %v", err)
+						return
+					}
+					continue
+				}
+
+				ip, err := pool.Allocate()
+				if errors.Is(err, ErrPoolExhausted) {
+					continue
+				}
+				if err != nil {
+					t.Errorf("Allocate error: %v", err)
+					return
+				}
+				if _, dup := owners.LoadOrStore(ip.String(), true); dup {
+					t.Errorf("%v handed out twice", ip)
+					return
+				}
+				held = append(held, ip)
+			}
+			for _, ip := range held {
+				owners.Delete(ip.String())
+				if err := pool.Release(ip); err != nil {
+					t.Errorf("Release error: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Everything was given back, so the whole network can be allocated again
+	for i := 0; i < 256; i++ {
+		if _, err := pool.Allocate(); err != nil {
+			t.Fatalf("Allocate %d after churn error: %v", i, err)
+		}
+	}
+	if _, err := pool.Allocate(); !errors.Is(err, ErrPoolExhausted) {
+		t.Errorf("Allocate past size err = %v; want ErrPoolExhausted", err)
+	}
+}
@@ -0,0 +1,162 @@
+package cidrx
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"net/netip"
+)
+
+// AllocateN appends n free addresses to dst and returns the extended slice. Either all n are allocated or none is: if
+// fewer than n addresses are left, ErrPoolExhausted is returned along with dst unchanged before any block is created.
+// The lock is taken once and, with the default allocation strategy, free bits are claimed a whole bitmap
+// word at a time, so it is much cheaper than n calls to AllocateAddr. Addresses come in allocation order, which is not
+// necessarily address order.
+func (p *Pool) AllocateN(dst []netip.Addr, n int) ([]netip.Addr, error) {
+	if n < 0 {
+		return dst, fmt.Errorf("%w: can't allocate %d addresses", ErrInvalidConfig, n)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.available(uint64(n)) {
+		return dst, ErrPoolExhausted
+	}
+
+	start := len(dst)
+	take := p.takeWords
+	if p.strategy != nil {
+		take = p.takeEach
+	}
+	dst, err := take(dst, uint64(n))
+	if err != nil {
+		_ = p.undoAllocateN(dst[start:], 0)
+		return dst[:start], err
+	}
+
+	for i, addr := range dst[start:] {
+		if errJournal := p.record(journalAllocate, fromAddr(addr)); errJournal != nil {
+			// The caller never sees the addresses, so hand them all back
+			return dst[:start], errors.Join(errJournal, p.undoAllocateN(dst[start:], i))
+		}
+	}
+	for i := start; i < len(dst); i++ {
+		dst[i] = p.outAddr(dst[i])
+	}
+	return dst, nil
+}
+
+// available reports whether n more addresses can be handed out. The free addresses of the created blocks are counted
+// first, so the blocks not created yet and the excluded ranges are only looked at when those fall short.
+func (p *Pool) available(n uint64) bool {
+	var free uint64
+	for _, bi := range p.freeList {
+		if free >= n {
+			return true
+		}
+		free += p.blocks[bi].freeCount
+	}
+	if free >= n {
+		return true
+	}
+
+	// The rest must come from the blocks not created yet, whose excluded addresses don't count
+	var excluded Uint128
+	for _, r := range p.excluded {
+		excluded = excluded.add(r.last.sub(r.first).add(Uint128{Lo: 1}))
+	}
+	for _, blk := range p.blocks {
+		excluded = excluded.sub(Uint128{Lo: blk.excluded})
+	}
+	need := Uint128{Lo: n - free}.add(excluded)
+
+	// need ≤ uncreated<<hostBits, written so that a /0 network, whose capacity is 2^128, does not wrap around
+	uncreated := p.maxBlocks - uint64(len(p.blocks))
+	return need.sub(Uint128{Lo: 1}).rsh(p.hostBits).cmp(Uint128{Lo: uncreated}) < 0
+}
+
+// takeWords appends n addresses to dst, claiming free bits a whole word at a time as the default strategy would hand
+// them out. On error dst holds the addresses taken so far.
+func (p *Pool) takeWords(dst []netip.Addr, n uint64) ([]netip.Addr, error) {
+	for n > 0 {
+		bi, blk, ok := p.allocBlock()
+		if !ok {
+			return dst, ErrPoolExhausted
+		}
+		if blk.freeCount == 0 {
+			// A new block may be full already if its free addresses are all excluded
+			continue
+		}
+
+		wi, taken := blk.allocWord(n)
+		p.relist(bi, blk)
+		n -= uint64(bits.OnesCount64(taken))
+		for ; taken != 0; taken &= taken - 1 {
+			dst = append(dst, blk.bitToAddr(wi*64+uint64(bits.TrailingZeros64(taken))))
+		}
+	}
+	return dst, nil
+}
+
+// takeEach appends n addresses to dst, letting the allocation strategy pick each of them. On error dst holds the
+// addresses taken so far.
+func (p *Pool) takeEach(dst []netip.Addr, n uint64) ([]netip.Addr, error) {
+	for ; n > 0; n-- {
+		bi, idx, err := p.allocate()
+		if err != nil {
+			return dst, err
+		}
+		dst = append(dst, p.blocks[bi].bitToAddr(idx))
+	}
+	return dst, nil
+}
+
+// undoAllocateN hands back the addresses taken by a failed AllocateN, the first recorded of which were written to the
+// journal already
+func (p *Pool) undoAllocateN(addrs []netip.Addr, recorded int) error {
+	var errs []error
+	for i, addr := range addrs {
+		if err := p.unallocate(fromAddr(addr), i < recorded); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// unallocate frees u, taken by an operation that failed. A recorded address was already written to the journal, so it
+// is released through it as well.
+func (p *Pool) unallocate(u Uint128, recorded bool) error {
+	bi, idx, err := p.locate(u)
+	if err == nil {
+		if recorded {
+			err = p.freeRecorded(bi, idx, u)
+		} else {
+			err = p.free(bi, idx)
+		}
+	}
+	if err != nil {
+		return p.addressError("release", u, err)
+	}
+	return nil
+}
+
+// ReleaseMany frees every address of addrs under a single lock. An address that can't be released does not stop the
+// others: each failure is reported as an *AddressError, and all of them are joined into the returned error.
+func (p *Pool) ReleaseMany(addrs []netip.Addr) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var errs []error
+	for _, addr := range addrs {
+		u, ok := p.inAddr(addr)
+		if !ok {
+			errs = append(errs, notInPool("release", addr.AsSlice()))
+			continue
+		}
+		if err := p.releaseAt("release", u); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,166 @@
+package cidrx //nolint:testpackage // it's OK to be just cidrx
+
+import (
+	"errors"
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+// TestAllocateN ensures AllocateN appends distinct addresses across blocks and skips excluded ones
+func TestAllocateN(t *testing.T) {
+	pool, err := NewPool("10.0.0.0", 24, 26, 1, WithExcludedPrefixes(netip.MustParsePrefix("10.0.0.0/30")))
+	if err != nil {
+		t.Fatalf("NewPool error: %v", err)
+	}
+	first, err := pool.AllocateAddr()
+	if err != nil {
+		t.Fatalf("AllocateAddr error: %v", err)
+	}
+
+	dst := []netip.Addr{first}
+	dst, err = pool.AllocateN(dst, 150)
+	if err != nil {
+		t.Fatalf("AllocateN error: %v", err)
+	}
+	if len(dst) != 151 || dst[0] != first {
+		t.Fatalf("AllocateN returned %d addresses starting with %s; want 151 starting with %s", len(dst), dst[0], first)
+	}
+
+	seen := make(map[netip.Addr]bool)
+	for _, addr := range dst {
+		if !addr.Is4() || !pool.Contains(addr) || seen[addr] {
+			t.Errorf("AllocateN returned %s: not an IPv4 address of the pool, or a duplicate", addr)
+		}
+		if !pool.IsAllocatedAddr(addr) {
+			t.Errorf("%s not allocated after AllocateN", addr)
+		}
+		seen[addr] = true
+	}
+	if n := pool.allocated(); n != 151 {
+		t.Errorf("allocated() = %d; want 151", n)
+	}
+	if errVerify := pool.Verify(); errVerify != nil {
+		t.Errorf("Verify error: %v", errVerify)
+	}
+}
+
+// TestAllocateNExhausted ensures AllocateN allocates nothing when the pool can't satisfy the whole request
+func TestAllocateNExhausted(t *testing.T) {
+	pool, err := NewPool("2001:db8::", 120, 124, 1)
+	if err != nil {
+		t.Fatalf("NewPool error: %v", err)
+	}
+	if _, err = pool.AllocateN(nil, 200); err != nil {
+		t.Fatalf("AllocateN error: %v", err)
+	}
+
+	dst := make([]netip.Addr, 0, 64)
+	got, err := pool.AllocateN(dst, 57)
+	if !errors.Is(err, ErrPoolExhausted) || len(got) != 0 {
+		t.Fatalf("AllocateN past capacity = %d addresses, err %v; want none and ErrPoolExhausted", len(got), err)
+	}
+	if n := pool.allocated(); n != 200 {
+		t.Errorf("allocated() after failed AllocateN = %d; want 200", n)
+	}
+	if errVerify := pool.Verify(); errVerify != nil {
+		t.Errorf("Verify error: %v", errVerify)
+	}
+
+	// Exactly what is left still fits
+	if got, err = pool.AllocateN(dst, 56); err != nil || len(got) != 56 {
+		t.Errorf("AllocateN of the remaining space = %d addresses, err %v; want 56", len(got), err)
+	}
+	if _, err = pool.AllocateN(nil, -1); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("AllocateN(-1) err = %v; want ErrInvalidConfig", err)
+	}
+}
+
+// TestAllocateNExhaustedNoBlocks ensures a request larger than the pool fails before creating any block, counting
+// excluded addresses out of the blocks not created yet
+func TestAllocateNExhaustedNoBlocks(t *testing.T) {
+	// /112 with /120 blocks => 256 blocks of 256 addresses, 4096 of them excluded
+	pool, err := NewPool("2001:db8::", 112, 120, 1, WithExcludedPrefixes(netip.MustParsePrefix("2001:db8::8000/116")))
+	if err != nil {
+		t.Fatalf("NewPool error: %v", err)
+	}
+	if _, err = pool.AllocateN(nil, 100000); !errors.Is(err, ErrPoolExhausted) {
+		t.Fatalf("AllocateN(100000) err = %v; want ErrPoolExhausted", err)
+	}
+	if _, err = pool.AllocateN(nil, 61441); !errors.Is(err, ErrPoolExhausted) {
+		t.Fatalf("AllocateN(61441) err = %v; want ErrPoolExhausted", err)
+	}
+	if blocks := pool.Stats().Blocks; blocks != 0 {
+		t.Errorf("Blocks after failed AllocateN = %d; want 0", blocks)
+	}
+
+	if _, err = pool.AllocateN(nil, 61440); err != nil {
+		t.Errorf("AllocateN of the whole usable pool error: %v", err)
+	}
+	if errVerify := pool.Verify(); errVerify != nil {
+		t.Errorf("Verify error: %v", errVerify)
+	}
+}
+
+// TestReleaseMany ensures ReleaseMany releases what it can and reports every other address
+func TestReleaseMany(t *testing.T) {
+	pool, err := NewPool("2001:db8::", 64, 120, 1)
+	if err != nil {
+		t.Fatalf("NewPool error: %v", err)
+	}
+	addrs, err := pool.AllocateN(nil, 10)
+	if err != nil {
+		t.Fatalf("AllocateN error: %v", err)
+	}
+
+	outside := netip.MustParseAddr("2001:db9::1")
+	unallocated := netip.MustParseAddr("2001:db8::ff")
+	batch := slices.Concat(addrs, []netip.Addr{outside, addrs[3], unallocated})
+	errRelease := pool.ReleaseMany(batch)
+
+	joined, ok := errRelease.(interface{ Unwrap() []error }) //nolint:errorlint // inspecting the joined errors
+	if !ok {
+		t.Fatalf("ReleaseMany err = %v; want the joined per-address errors", errRelease)
+	}
+	want := map[netip.Addr]error{outside: ErrNotInPool, addrs[3]: ErrNotAllocated, unallocated: ErrNotAllocated}
+	if len(joined.Unwrap()) != len(want) {
+		t.Errorf("ReleaseMany reported %d errors; want %d", len(joined.Unwrap()), len(want))
+	}
+	for _, e := range joined.Unwrap() {
+		var addrErr *AddressError
+		if !errors.As(e, &addrErr) || !errors.Is(e, want[addrErr.Addr]) {
+			t.Errorf("ReleaseMany error %v; want an *AddressError matching the address failure", e)
+		}
+	}
+
+	if n := pool.allocated(); n != 0 {
+		t.Errorf("allocated() after ReleaseMany = %d; want 0", n)
+	}
+	if errNone := pool.ReleaseMany(nil); errNone != nil {
+		t.Errorf("ReleaseMany(nil) error: %v", errNone)
+	}
+}
+
+// TestAllocateNJournal ensures every address of a batch is journaled and recovered
+func TestAllocateNJournal(t *testing.T) {
+	dir := t.TempDir()
+	newPool := func() (*Pool, error) { return NewPool("2001:db8::", 64, 124, 4) }
+	j, err := OpenJournal(dir, JournalOptions{}, newPool)
+	if err != nil {
+		t.Fatalf("OpenJournal error: %v", err)
+	}
+	addrs, err := j.Pool().AllocateN(nil, 300)
+	if err != nil {
+		t.Fatalf("AllocateN error: %v", err)
+	}
+	crashJournal(t, j)
+
+	recovered, err := OpenJournal(dir, JournalOptions{}, newPool)
+	if err != nil {
+		t.Fatalf("recover error: %v", err)
+	}
+	defer func() { _ = recovered.Close() }()
+	if errRelease := recovered.Pool().ReleaseMany(addrs); errRelease != nil {
+		t.Errorf("ReleaseMany after recovery: %v", errRelease)
+	}
+}
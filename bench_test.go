@@ -2,6 +2,7 @@ package cidrx //nolint:testpackage // it's OK to be just cidrx
 
 import (
 	"net"
+	"net/netip"
 	"sync"
 	"testing"
 )
@@ -63,14 +64,108 @@ func BenchmarkConcurrentAlloc(b *testing.B) {
 		go func() {
 			defer wg.Done()
 			_, err := pool.Allocate()
+			if err != nil {
+				b.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func BenchmarkShardedAllocateReleaseMixed(b *testing.B) {
+	b.ReportAllocs()
+	pool, _ := NewShardedPool("2001:db8::", 64, 120, 1024, 16)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			ip, err := pool.Allocate()
 			if err != nil {
 				b.Fatal(err)
 			}
+			if errRelease := pool.Release(ip); errRelease != nil {
+				b.Fatal(errRelease)
+			}
+		}
+	})
+}
+
+func BenchmarkShardedConcurrentAlloc(b *testing.B) {
+	b.ReportAllocs()
+	pool, _ := NewShardedPool("2001:db8::", 64, 120, 1024, 16)
+	var wg sync.WaitGroup
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := pool.Allocate()
+			if err != nil {
+				b.Error(err)
+			}
 		}()
 	}
 	wg.Wait()
 }
 
+// BenchmarkParallelAllocateRelease compares a Pool, whose single mutex serializes every call, with an AtomicPool,
+// which claims bits with compare-and-swap, when all goroutines allocate and release in the same /112 block
+func BenchmarkParallelAllocateRelease(b *testing.B) {
+	b.Run("Mutex", func(b *testing.B) {
+		b.ReportAllocs()
+		pool, _ := NewPool("2001:db8::", 64, 112, 1024)
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				ip, err := pool.Allocate()
+				if err != nil {
+					b.Fatal(err)
+				}
+				if errRelease := pool.Release(ip); errRelease != nil {
+					b.Fatal(errRelease)
+				}
+			}
+		})
+	})
+
+	b.Run("Atomic", func(b *testing.B) {
+		b.ReportAllocs()
+		pool, _ := NewAtomicPool("2001:db8::", 64, 112, 1024)
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				ip, err := pool.Allocate()
+				if err != nil {
+					b.Fatal(err)
+				}
+				if errRelease := pool.Release(ip); errRelease != nil {
+					b.Fatal(errRelease)
+				}
+			}
+		})
+	})
+}
+
+// BenchmarkAllocNearlyFullBlock frees and reallocates the last bit of an otherwise full /104 block (262.144 words)
+func BenchmarkAllocNearlyFullBlock(b *testing.B) {
+	b.ReportAllocs()
+	prefix := net.IPNet{IP: net.ParseIP("2001:db8::"), Mask: net.CIDRMask(104, 128)}
+	blk := newBlock(prefix, 1<<24)
+	words := make([]uint64, len(blk.used))
+	for i := range words {
+		words[i] = ^uint64(0)
+	}
+	if err := blk.load(words); err != nil {
+		b.Fatal(err)
+	}
+	last := blk.size - 1
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := blk.releaseBit(last); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := blk.allocBit(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 func BenchmarkBlockIndexForIP(b *testing.B) {
 	b.ReportAllocs()
 	pool, _ := NewPool("2001:db8::", 64, 120, 1024)
@@ -221,3 +316,138 @@ func BenchmarkReleaseVariousCIDRs(b *testing.B) { //nolint:gocognit // test func
 		})
 	}
 }
+
+func BenchmarkAllocateAddrSequential(b *testing.B) {
+	b.ReportAllocs()
+	pool, _ := NewPoolFromPrefix(netip.MustParsePrefix("2001:db8::/64"), 120, 1024)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := pool.AllocateAddr(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkReleaseAddrSequential(b *testing.B) {
+	b.ReportAllocs()
+	pool, _ := NewPoolFromPrefix(netip.MustParsePrefix("2001:db8::/64"), 120, 1024)
+	addrs := make([]netip.Addr, b.N)
+	for i := 0; i < b.N; i++ {
+		addr, err := pool.AllocateAddr()
+		if err != nil {
+			b.Fatal(err)
+		}
+		addrs[i] = addr
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := pool.ReleaseAddr(addrs[i]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkAllocateBlockSize100 creates a /100 block (a 32 MB bitmap) on every Allocate, comparing fresh bitmaps with
+// recycled ones. Each iteration releases the address again, which reclaims the block.
+func BenchmarkAllocateBlockSize100(b *testing.B) {
+	for _, c := range []struct {
+		name string
+		opts []Option
+	}{
+		{"Fresh", []Option{WithBlockReclaim(0)}},
+		{"Recycled", []Option{WithBlockReclaim(0), WithBitmapRecycling(64 << 20)}},
+	} {
+		b.Run(c.name, func(b *testing.B) {
+			pool, err := NewPool("2001:db8::", 64, 100, 1024, c.opts...)
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				addr, errAllocate := pool.AllocateAddr()
+				if errAllocate != nil {
+					b.Fatal(errAllocate)
+				}
+				if errRelease := pool.ReleaseAddr(addr); errRelease != nil {
+					b.Fatal(errRelease)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkAllocateBatch compares allocating 256 addresses one by one with a single AllocateN call
+func BenchmarkAllocateBatch(b *testing.B) {
+	const batch = 256
+
+	b.Run("AllocateAddr", func(b *testing.B) {
+		b.ReportAllocs()
+		pool, _ := NewPool("2001:db8::", 64, 112, 1024)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			for j := 0; j < batch; j++ {
+				if _, err := pool.AllocateAddr(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("AllocateN", func(b *testing.B) {
+		b.ReportAllocs()
+		pool, _ := NewPool("2001:db8::", 64, 112, 1024)
+		dst := make([]netip.Addr, 0, batch)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := pool.AllocateN(dst, batch); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// BenchmarkAllocationStrategy measures each strategy under churn: every op releases the oldest of 4096 held addresses
+// and allocates a new one
+func BenchmarkAllocationStrategy(b *testing.B) {
+	const held = 4096
+
+	strategies := []struct {
+		name     string
+		strategy func() AllocationStrategy
+	}{
+		{"Default", nil},
+		{"FirstFit", FirstFit},
+		{"RandomFit", RandomFit},
+		{"RoundRobin", RoundRobin},
+		{"LeastRecentlyReleased", func() AllocationStrategy { return LeastRecentlyReleased(1024) }},
+	}
+	for _, s := range strategies {
+		b.Run(s.name, func(b *testing.B) {
+			b.ReportAllocs()
+			var opts []Option
+			if s.strategy != nil {
+				opts = append(opts, WithAllocationStrategy(s.strategy()))
+			}
+			pool, _ := NewPool("2001:db8::", 64, 112, 1024, opts...)
+			addrs := make([]netip.Addr, held)
+			for i := range addrs {
+				addr, err := pool.AllocateAddr()
+				if err != nil {
+					b.Fatal(err)
+				}
+				addrs[i] = addr
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := pool.ReleaseAddr(addrs[i%held]); err != nil {
+					b.Fatal(err)
+				}
+				addr, err := pool.AllocateAddr()
+				if err != nil {
+					b.Fatal(err)
+				}
+				addrs[i%held] = addr
+			}
+		})
+	}
+}
@@ -2,51 +2,295 @@ package cidrx
 
 import (
 	"encoding/binary"
+	"fmt"
 	"math/bits"
+	"math/rand/v2"
 	"net"
+	"net/netip"
 )
 
 // block represents a fixed-size bitmap for one IPv6 CIDR segment.
 type block struct {
 	prefix    net.IPNet
+	base      Uint128  // first address of prefix
 	used      []uint64 // bitmap words: 1 means allocated
 	freeCount uint64   // how many bits are still free
 	size      uint64   // total bits
+	excluded  uint64   // how many set bits belong to excluded addresses
+	listed    bool     // whether the block is in the pool freeList
+	listPos   int      // position in the pool freeList when listed
+
+	// summary[0] has bit i set when used[i] still has a free bit, and summary[l+1] has bit i set when summary[l][i]
+	// is non-zero. The last level is a single word, so a free bit is found in O(log64 words) at any fill level.
+	summary [][]uint64
 }
 
 // newBlock creates a block for the given prefix and size.
 func newBlock(prefix net.IPNet, size uint64) *block {
 	words := int((size + 63) / 64)
-	return &block{
+	b := &block{
 		prefix:    prefix,
+		base:      fromIP(prefix.IP),
 		used:      make([]uint64, words),
 		freeCount: size,
 		size:      size,
 	}
+
+	for n := words; ; n = (n + 63) / 64 {
+		b.summary = append(b.summary, make([]uint64, (n+63)/64))
+		if n <= 64 {
+			break
+		}
+	}
+	b.resetSummary()
+	return b
+}
+
+// reuse turns b, whose bitmap must be all zeros, into an empty block for prefix. It lets a reclaimed bitmap back a new
+// block without allocating and zeroing it again.
+func (b *block) reuse(prefix net.IPNet) {
+	b.prefix = prefix
+	b.base = fromIP(prefix.IP)
+	b.freeCount = b.size
+	b.excluded = 0
+	b.listed = false
+	b.resetSummary()
+}
+
+// resetSummary marks every word as having free bits. Only the summary is touched, which keeps a zeroed bitmap itself
+// untouched until it is used.
+func (b *block) resetSummary() {
+	// Each level has its first n bits set, n being the number of entries of the level below
+	n := len(b.used)
+	for _, level := range b.summary {
+		for i := range level {
+			level[i] = ^uint64(0)
+		}
+		if tail := n % 64; tail != 0 {
+			level[len(level)-1] = 1<<tail - 1
+		}
+		n = len(level)
+	}
+}
+
+// load replaces the bitmap with words, recomputing freeCount and the summary. Bits past size in the last word are
+// dropped.
+func (b *block) load(words []uint64) error {
+	copy(b.used, words)
+	last := uint64(len(b.used) - 1)
+	b.used[last] &= b.wordMask(last)
+
+	var usedCount uint64
+	for _, w := range b.used {
+		usedCount += uint64(bits.OnesCount64(w))
+	}
+	if usedCount > b.size {
+		return fmt.Errorf("%w: %d bits set in a block of %d", ErrInconsistent, usedCount, b.size)
+	}
+	b.freeCount = b.size - usedCount
+
+	// Rebuild the summary bottom up: level 0 from the bitmap, every other level from the one below
+	lower := len(b.used)
+	for l, level := range b.summary {
+		clear(level)
+		for i := 0; i < lower; i++ {
+			var hasFree bool
+			if l == 0 {
+				hasFree = !b.wordFull(uint64(i))
+			} else {
+				hasFree = b.summary[l-1][i] != 0
+			}
+			if hasFree {
+				level[i/64] |= 1 << (i % 64)
+			}
+		}
+		lower = len(level)
+	}
+	return nil
 }
 
 // allocBit finds and sets the first zero bit, returning its index
 func (b *block) allocBit() (uint64, error) {
-	// Iterate over the bitmap words to find a free bit
-	for wi, word := range b.used {
-		// Check if the word is not full by checking if the bitwise NOT is not zero
-		if ^word != 0 {
-			// Find the first zero bit in the word
-			bit := bits.TrailingZeros64(^word)
-			// Retrieve the index of the zero bit
-			idx := uint64(wi)*64 + uint64(bit)
-			if idx >= b.size {
-				continue
-			}
+	if b.freeCount == 0 {
+		return 0, ErrBlockFull
+	}
+
+	// Walk the summary from the top, always taking the lowest child with a free bit, down to a bitmap word
+	var wi uint64
+	for l := len(b.summary) - 1; l >= 0; l-- {
+		wi = wi*64 + uint64(bits.TrailingZeros64(b.summary[l][wi]))
+	}
+
+	// Find the first zero bit in the word, which lies below size since the summary only marks words with free bits
+	bit := bits.TrailingZeros64(^b.used[wi])
+	b.used[wi] |= 1 << bit
+	b.freeCount--
+	if b.wordFull(wi) {
+		b.markFull(wi)
+	}
+
+	return wi*64 + uint64(bit), nil
+}
+
+// allocWord sets up to n free bits, n > 0, of the first bitmap word that has any, the lowest ones first. Returns the
+// word index and the bits it set. The block must have a free bit.
+func (b *block) allocWord(n uint64) (uint64, uint64) {
+	var wi uint64
+	for l := len(b.summary) - 1; l >= 0; l-- {
+		wi = wi*64 + uint64(bits.TrailingZeros64(b.summary[l][wi]))
+	}
+
+	free := ^b.used[wi] & b.wordMask(wi)
+	taken := free
+	if uint64(bits.OnesCount64(free)) > n {
+		for taken = 0; n > 0; n-- {
+			low := free & -free
+			taken |= low
+			free ^= low
+		}
+	}
+
+	b.setBits(wi, taken)
+	return wi, taken
+}
+
+// allocFrom finds and sets the first zero bit at or after from, returning its index, or false if there is none
+func (b *block) allocFrom(from uint64) (uint64, bool) {
+	if from >= b.size || b.freeCount == 0 {
+		return 0, false
+	}
+
+	wi := from / 64
+	free := ^b.used[wi] & b.wordMask(wi) & (^uint64(0) << (from % 64))
+	if free == 0 {
+		var ok bool
+		if wi, ok = b.nextFreeWord(wi + 1); !ok {
+			return 0, false
+		}
+		free = ^b.used[wi] & b.wordMask(wi)
+	}
+
+	bit := uint64(bits.TrailingZeros64(free))
+	b.setBits(wi, 1<<bit)
+	return wi*64 + bit, true
+}
+
+// allocRandom sets a zero bit picked by rng and returns its index. The block must have a free bit. Words are picked
+// uniformly and the first one with a free bit from there is used, so the choice is unpredictable but not uniform
+// when free bits are unevenly spread.
+func (b *block) allocRandom(rng *rand.Rand) uint64 {
+	wi, ok := b.nextFreeWord(rng.Uint64N(uint64(len(b.used))))
+	if !ok {
+		wi, _ = b.nextFreeWord(0)
+	}
+
+	free := ^b.used[wi] & b.wordMask(wi)
+	for k := rng.IntN(bits.OnesCount64(free)); k > 0; k-- {
+		free &= free - 1
+	}
+	bit := uint64(bits.TrailingZeros64(free))
+	b.setBits(wi, 1<<bit)
+	return wi*64 + bit
+}
+
+// nextFreeWord returns the index of the first bitmap word at or after wi with a free bit, or false if there is none
+func (b *block) nextFreeWord(wi uint64) (uint64, bool) {
+	// Go up the summary until a level has a bit set at or after the position, pos being a bit index in that level
+	pos := wi
+	l := 0
+	for ; l < len(b.summary); l++ {
+		if pos/64 >= uint64(len(b.summary[l])) {
+			return 0, false
+		}
+		if w := b.summary[l][pos/64] & (^uint64(0) << (pos % 64)); w != 0 {
+			pos = pos/64*64 + uint64(bits.TrailingZeros64(w))
+			break
+		}
+		pos = pos/64 + 1
+	}
+	if l == len(b.summary) {
+		return 0, false
+	}
+
+	// Then down again, always taking the lowest child with a free bit
+	for ; l > 0; l-- {
+		pos = pos*64 + uint64(bits.TrailingZeros64(b.summary[l-1][pos]))
+	}
+	return pos, true
+}
 
-			// Set the bit to 1 (allocated)
-			b.used[wi] |= 1 << bit
-			b.freeCount--
+// setBits sets the zero bits of mask in used[wi]
+func (b *block) setBits(wi, mask uint64) {
+	b.used[wi] |= mask
+	b.freeCount -= uint64(bits.OnesCount64(mask))
+	if b.wordFull(wi) {
+		b.markFull(wi)
+	}
+}
+
+// claimBit sets the bit at idx, failing if it is already allocated
+func (b *block) claimBit(idx uint64) error {
+	if idx >= b.size {
+		return ErrOutOfRange
+	}
 
-			return idx, nil
+	wi := idx / 64
+	bit := idx % 64
+	if b.used[wi]&(1<<bit) != 0 {
+		return ErrAlreadyAllocated
+	}
+
+	b.used[wi] |= 1 << bit
+	b.freeCount--
+	if b.wordFull(wi) {
+		b.markFull(wi)
+	}
+	return nil
+}
+
+// excludeRange marks bits from-to (inclusive) as excluded, setting those that are not set yet
+func (b *block) excludeRange(from, to uint64) {
+	for wi := from / 64; wi <= to/64; wi++ {
+		mask := spanMask(wi, from, to)
+		added := mask &^ b.used[wi]
+		b.used[wi] |= mask
+		b.freeCount -= uint64(bits.OnesCount64(added))
+		if added != 0 && b.wordFull(wi) {
+			b.markFull(wi)
 		}
 	}
-	return 0, ErrBlockFull
+	b.excluded += to - from + 1
+}
+
+// spanMask returns the bits of word wi that lie between bits from and to (inclusive)
+func spanMask(wi, from, to uint64) uint64 {
+	mask := ^uint64(0)
+	if wi == from/64 {
+		mask &= ^uint64(0) << (from % 64)
+	}
+	if wi == to/64 {
+		mask &= ^uint64(0) >> (63 - to%64)
+	}
+	return mask
+}
+
+// bitmapBytes returns the memory held by the bitmap and its summary levels
+func (b *block) bitmapBytes() uint64 {
+	words := len(b.used)
+	for _, level := range b.summary {
+		words += len(level)
+	}
+	return uint64(words) * 8
+}
+
+// empty reports whether no address of the block is allocated, excluded ones aside
+func (b *block) empty() bool {
+	return b.freeCount+b.excluded == b.size
+}
+
+// isSet reports whether the bit at idx is allocated, idx must be below size
+func (b *block) isSet(idx uint64) bool {
+	return b.used[idx/64]&(1<<(idx%64)) != 0
 }
 
 // releaseBit clears the bit at idx
@@ -64,12 +308,53 @@ func (b *block) releaseBit(idx uint64) error {
 		return ErrNotAllocated
 	}
 
-	// Clear the bit (release it)
+	// Clear the bit (release it), the word has a free bit again if it was full
+	wasFull := b.wordFull(wi)
 	b.used[wi] &^= 1 << bit
 	b.freeCount++
+	if wasFull {
+		b.markFree(wi)
+	}
 	return nil
 }
 
+// wordFull reports whether every bit of used[wi] that lies below size is allocated
+func (b *block) wordFull(wi uint64) bool {
+	full := b.wordMask(wi)
+	return b.used[wi]&full == full
+}
+
+// wordMask returns the bits of used[wi] that lie below size
+func (b *block) wordMask(wi uint64) uint64 {
+	if tail := b.size % 64; tail != 0 && wi == uint64(len(b.used)-1) {
+		return 1<<tail - 1
+	}
+	return ^uint64(0)
+}
+
+// markFull clears the summary bit of the bitmap word wi, propagating upwards while summary words become empty
+func (b *block) markFull(wi uint64) {
+	for _, level := range b.summary {
+		level[wi/64] &^= 1 << (wi % 64)
+		if level[wi/64] != 0 {
+			return
+		}
+		wi /= 64
+	}
+}
+
+// markFree sets the summary bit of the bitmap word wi, propagating upwards while summary words were empty
+func (b *block) markFree(wi uint64) {
+	for _, level := range b.summary {
+		wasEmpty := level[wi/64] == 0
+		level[wi/64] |= 1 << (wi % 64)
+		if !wasEmpty {
+			return
+		}
+		wi /= 64
+	}
+}
+
 // bitToIP converts a bit index into an IPv6 address within this block
 func (b *block) bitToIP(idx uint64) net.IP {
 	// Split block base address into high and low parts
@@ -88,27 +373,19 @@ func (b *block) bitToIP(idx uint64) net.IP {
 	return ip
 }
 
-// ipToBit returns the bit index for the given IP in this block
-func (b *block) ipToBit(ip net.IP) (uint64, error) {
-	// Load block base address
-	h0 := binary.BigEndian.Uint64(b.prefix.IP[:8])
-	l0 := binary.BigEndian.Uint64(b.prefix.IP[8:])
-
-	// Load target IP
-	h1 := binary.BigEndian.Uint64(ip[:8])
-	l1 := binary.BigEndian.Uint64(ip[8:])
-
-	// Check if the IP is within the block range
-	dL, borrow := bits.Sub64(l1, l0, 0)
-	dH, under := bits.Sub64(h1, h0, borrow)
+// bitToAddr converts a bit index into an address within this block without allocating
+func (b *block) bitToAddr(idx uint64) netip.Addr {
+	return b.base.add(Uint128{Lo: idx}).toAddr()
+}
 
-	// Bound-check against block size
-	if under != 0 || dH != 0 {
+// offsetOf returns the bit index for the given address in this block
+func (b *block) offsetOf(addr Uint128) (uint64, error) {
+	if addr.cmp(b.base) < 0 {
 		return 0, ErrOutOfRange
 	}
-	if dL >= b.size {
+	delta := addr.sub(b.base)
+	if delta.Hi != 0 || delta.Lo >= b.size {
 		return 0, ErrOutOfRange
 	}
-
-	return dL, nil
+	return delta.Lo, nil
 }
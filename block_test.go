@@ -26,9 +26,9 @@ func TestBlockAllocRelease(t *testing.T) {
 	}
 	// Release and reallocate
 	for i, ip := range ips {
-		delta, err := b.ipToBit(ip)
+		delta, err := b.offsetOf(fromIP(ip))
 		if err != nil || delta != uint64(i) {
-			t.Errorf("ipToBit: got %d, want %d", delta, i)
+			t.Errorf("offsetOf: got %d, want %d", delta, i)
 		}
 		if errRelease := b.releaseBit(delta); errRelease != nil {
 			t.Errorf("releaseBit #%d error: %v", i, errRelease)
@@ -150,22 +150,109 @@ func TestRoundTripLargeBlock(t *testing.T) {
 	// Test a few key indices
 	for _, idx := range []uint64{0, 1, 63, 64, 127, 128, 255} {
 		ip := b.bitToIP(idx)
-		got, err := b.ipToBit(ip)
+		got, err := b.offsetOf(fromIP(ip))
 		if err != nil {
-			t.Errorf("ipToBit(%v) error: %v", ip, err)
+			t.Errorf("offsetOf(%v) error: %v", ip, err)
 		}
 		if got != idx {
-			t.Errorf("ipToBit(%v) = %d; want %d", ip, got, idx)
+			t.Errorf("offsetOf(%v) = %d; want %d", ip, got, idx)
 		}
 	}
 }
 
-func TestBlockIpToBitOutOfRange(t *testing.T) {
+func TestBlockOffsetOfOutOfRange(t *testing.T) {
 	prefix := net.IPNet{IP: net.ParseIP("2001:db8::"), Mask: net.CIDRMask(120, 128)}
 	b := newBlock(prefix, 2)
 	// IP outside block
 	external := net.ParseIP("2001:db8::100")
-	if _, err := b.ipToBit(external); err == nil {
+	if _, err := b.offsetOf(fromIP(external)); err == nil {
 		t.Error("Expected ErrOutOfRange, got nil")
 	}
 }
+
+// TestAllocBitSummary ensures the summary keeps allocBit first-fit as words fill up and are released
+func TestAllocBitSummary(t *testing.T) {
+	prefix := net.IPNet{IP: net.ParseIP("2001:db8::"), Mask: net.CIDRMask(114, 128)}
+	size := uint64(1 << 14) // 256 words => two summary levels
+	b := newBlock(prefix, size)
+	for i := uint64(0); i < size; i++ {
+		if _, err := b.allocBit(); err != nil {
+			t.Fatalf("allocBit #%d: %v", i, err)
+		}
+	}
+
+	// Free bits in words far apart, they must come back lowest first
+	released := []uint64{16383, 64, 4095, 5, 8192}
+	for _, idx := range released {
+		if err := b.releaseBit(idx); err != nil {
+			t.Fatalf("releaseBit(%d): %v", idx, err)
+		}
+	}
+	for _, want := range []uint64{5, 64, 4095, 8192, 16383} {
+		idx, err := b.allocBit()
+		if err != nil || idx != want {
+			t.Errorf("allocBit = %d, %v; want %d", idx, err, want)
+		}
+	}
+	if _, err := b.allocBit(); !errors.Is(err, ErrBlockFull) {
+		t.Errorf("expected ErrBlockFull, got %v", err)
+	}
+}
+
+// TestBlockLoad ensures loading a bitmap restores freeCount and the summary
+func TestBlockLoad(t *testing.T) {
+	prefix := net.IPNet{IP: net.ParseIP("2001:db8::"), Mask: net.CIDRMask(120, 128)}
+	b := newBlock(prefix, 256)
+	if err := b.load([]uint64{^uint64(0), ^uint64(0), ^uint64(0) &^ (1 << 7), ^uint64(0)}); err != nil {
+		t.Fatalf("load error: %v", err)
+	}
+
+	if b.freeCount != 1 {
+		t.Errorf("freeCount = %d; want 1", b.freeCount)
+	}
+	if idx, err := b.allocBit(); err != nil || idx != 135 {
+		t.Errorf("allocBit = %d, %v; want 135", idx, err)
+	}
+	if _, err := b.allocBit(); !errors.Is(err, ErrBlockFull) {
+		t.Errorf("expected ErrBlockFull, got %v", err)
+	}
+}
+
+// TestAllocFrom ensures allocFrom takes the first free bit at or after a position, walking the summary across words
+func TestAllocFrom(t *testing.T) {
+	prefix := net.IPNet{IP: net.ParseIP("2001:db8::"), Mask: net.CIDRMask(108, 128)}
+	size := uint64(1 << 20) // 16384 words => three summary levels
+	b := newBlock(prefix, size)
+	full := make([]uint64, size/64)
+	for wi := range full {
+		full[wi] = ^uint64(0)
+	}
+	if err := b.load(full); err != nil {
+		t.Fatalf("load error: %v", err)
+	}
+	for _, idx := range []uint64{3, 70, 300000, 1048575} {
+		if err := b.releaseBit(idx); err != nil {
+			t.Fatalf("releaseBit(%d): %v", idx, err)
+		}
+	}
+
+	cases := []struct {
+		from, want uint64
+		found      bool
+	}{
+		{71, 300000, true},
+		{0, 3, true},
+		{4, 70, true},
+		{300001, 1048575, true},
+		{0, 0, false},
+	}
+	for _, c := range cases {
+		idx, found := b.allocFrom(c.from)
+		if found != c.found || (found && idx != c.want) {
+			t.Errorf("allocFrom(%d) = %d, %v; want %d, %v", c.from, idx, found, c.want, c.found)
+		}
+	}
+	if b.freeCount != 0 {
+		t.Errorf("freeCount = %d; want 0", b.freeCount)
+	}
+}
@@ -0,0 +1,146 @@
+package cidrx
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"math/bits"
+	"net/netip"
+	"slices"
+)
+
+// ContiguousRange is a run of consecutive addresses handed out by AllocateContiguous
+type ContiguousRange struct {
+	Start netip.Addr
+	Count uint64
+}
+
+// Last returns the last address of the range
+func (r ContiguousRange) Last() netip.Addr {
+	last := fromAddr(r.Start).add(Uint128{Lo: r.Count - 1}).toAddr()
+	if r.Start.Is4() {
+		return last.Unmap()
+	}
+	return last
+}
+
+// Prefix returns the prefix covering exactly the range. Returns false unless Count is a power of two and Start is
+// aligned to it.
+func (r ContiguousRange) Prefix() (netip.Prefix, bool) {
+	if r.Count == 0 || r.Count&(r.Count-1) != 0 {
+		return netip.Prefix{}, false
+	}
+	k := bits.TrailingZeros64(r.Count)
+	prefix, err := r.Start.Prefix(r.Start.BitLen() - k)
+	if err != nil || prefix.Addr() != r.Start {
+		return netip.Prefix{}, false
+	}
+	return prefix, true
+}
+
+// AllocateContiguous allocates n consecutive addresses, the first of which is a multiple of alignment, and returns
+// them as a ContiguousRange. alignment must be a power of two; when n is a power of two as well and alignment is at
+// least n, the range is a prefix (see ContiguousRange.Prefix). The range may span several blocks. The lowest free run
+// that fits is used, which means walking the pool in address order, so it costs time linear in the number of created
+// blocks. Returns ErrPoolExhausted if no free run fits.
+func (p *Pool) AllocateContiguous(n, alignment uint64) (ContiguousRange, error) {
+	if n == 0 || alignment == 0 || alignment&(alignment-1) != 0 {
+		return ContiguousRange{}, fmt.Errorf("%w: can't allocate %d addresses aligned to %d", ErrInvalidConfig, n,
+			alignment)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	start, ok := p.findRun(n, alignment)
+	if !ok {
+		return ContiguousRange{}, ErrPoolExhausted
+	}
+
+	// Every address of the run is free and not excluded, claiming one only fails if the pool state is inconsistent
+	for i := uint64(0); i < n; i++ {
+		if _, _, err := p.claim(start.add(Uint128{Lo: i})); err != nil {
+			_ = p.unallocateRun(start, i, 0)
+			return ContiguousRange{}, err
+		}
+	}
+	for i := uint64(0); i < n; i++ {
+		if errJournal := p.record(journalAllocate, start.add(Uint128{Lo: i})); errJournal != nil {
+			return ContiguousRange{}, errors.Join(errJournal, p.unallocateRun(start, n, i))
+		}
+	}
+	return ContiguousRange{Start: p.outAddr(start.toAddr()), Count: n}, nil
+}
+
+// ReleaseContiguous frees a range obtained from AllocateContiguous. Every address of the range must be allocated,
+// otherwise nothing is released and the *AddressError of the first one that isn't is returned.
+func (p *Pool) ReleaseContiguous(r ContiguousRange) error {
+	start, ok := p.inAddr(r.Start)
+	if !ok {
+		return notInPool("release", r.Start.AsSlice())
+	}
+	if r.Count == 0 {
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Check the whole range before touching any of it
+	for i := uint64(0); i < r.Count; i++ {
+		u := start.add(Uint128{Lo: i})
+		if _, _, err := p.releasable(u); err != nil {
+			return p.addressError("release", u, err)
+		}
+	}
+	for i := uint64(0); i < r.Count; i++ {
+		if err := p.releaseAt("release", start.add(Uint128{Lo: i})); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findRun returns the lowest address that is a multiple of alignment and starts n free addresses, or false if there
+// is none
+func (p *Pool) findRun(n, alignment uint64) (Uint128, bool) {
+	mask := Uint128{Lo: alignment - 1}
+	var start Uint128
+	found := false
+	m := runMerger{fn: func(first, last Uint128) bool {
+		s := first.add(mask).andNot(mask)
+		if s.cmp(first) < 0 || s.cmp(last) > 0 {
+			// Rounding up wrapped around, or went past the run
+			return true
+		}
+		if span := last.sub(s); span.Hi == 0 && span.Lo < n-1 {
+			return true
+		}
+		start, found = s, true
+		return false
+	}}
+
+	next := p.networkAddr
+	for _, bi := range slices.Sorted(maps.Keys(p.blocks)) {
+		blk := p.blocks[bi]
+		next = p.freeInBlock(next, blk.base, blk.used, m.add)
+		if m.stopped {
+			return start, found
+		}
+	}
+	p.freeAfter(next, m.add)
+	m.flush()
+	return start, found
+}
+
+// unallocateRun hands back the first n addresses of a run from start, the first recorded of which were written to
+// the journal already
+func (p *Pool) unallocateRun(start Uint128, n, recorded uint64) error {
+	var errs []error
+	for i := uint64(0); i < n; i++ {
+		if err := p.unallocate(start.add(Uint128{Lo: i}), i < recorded); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
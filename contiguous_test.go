@@ -0,0 +1,121 @@
+package cidrx //nolint:testpackage // it's OK to be just cidrx
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+)
+
+// TestAllocateContiguous ensures runs are taken from the lowest aligned free space, across blocks when needed
+func TestAllocateContiguous(t *testing.T) {
+	// /24 with /28 blocks => 16 blocks of 16 addresses
+	pool, err := NewPool("10.0.0.0", 24, 28, 1, WithExcludedPrefixes(netip.MustParsePrefix("10.0.0.82/32")))
+	if err != nil {
+		t.Fatalf("NewPool error: %v", err)
+	}
+	if _, err = pool.AllocateN(nil, 3); err != nil {
+		t.Fatalf("AllocateN error: %v", err)
+	}
+
+	cases := []struct {
+		n, alignment uint64
+		start        string
+		prefix       string
+	}{
+		{8, 8, "10.0.0.8", "10.0.0.8/29"},
+		{5, 1, "10.0.0.3", ""},
+		{32, 32, "10.0.0.32", "10.0.0.32/27"}, // spans two blocks that were never created
+		{16, 16, "10.0.0.16", "10.0.0.16/28"},
+		{4, 2, "10.0.0.64", "10.0.0.64/30"},
+		{16, 16, "10.0.0.96", "10.0.0.96/28"}, // 10.0.0.80/28 holds an excluded address
+		{3, 4, "10.0.0.68", ""},
+		{11, 1, "10.0.0.71", ""}, // ends right before the excluded address, in the next block
+	}
+	for _, c := range cases {
+		r, errAllocate := pool.AllocateContiguous(c.n, c.alignment)
+		if errAllocate != nil {
+			t.Fatalf("AllocateContiguous(%d, %d) error: %v", c.n, c.alignment, errAllocate)
+		}
+		if r.Start != netip.MustParseAddr(c.start) || r.Count != c.n {
+			t.Errorf("AllocateContiguous(%d, %d) = %s+%d; want %s+%d", c.n, c.alignment, r.Start, r.Count, c.start, c.n)
+		}
+		prefix, ok := r.Prefix()
+		if (c.prefix == "") == ok || (ok && prefix != netip.MustParsePrefix(c.prefix)) {
+			t.Errorf("Prefix() of %s+%d = %s, %v; want %q", r.Start, r.Count, prefix, ok, c.prefix)
+		}
+		for addr := r.Start; addr.Compare(r.Last()) <= 0; addr = addr.Next() {
+			if !pool.IsAllocatedAddr(addr) {
+				t.Errorf("%s of range %s+%d not allocated", addr, r.Start, r.Count)
+			}
+		}
+	}
+	if n := pool.allocated(); n != 98 {
+		t.Errorf("allocated() = %d; want 98", n)
+	}
+	if errVerify := pool.Verify(); errVerify != nil {
+		t.Errorf("Verify error: %v", errVerify)
+	}
+}
+
+// TestAllocateContiguousExhausted ensures requests that can't be met fail without allocating anything
+func TestAllocateContiguousExhausted(t *testing.T) {
+	pool, err := NewPool("2001:db8::", 120, 124, 1)
+	if err != nil {
+		t.Fatalf("NewPool error: %v", err)
+	}
+	if err = pool.ReserveAddr(netip.MustParseAddr("2001:db8::80")); err != nil {
+		t.Fatalf("ReserveAddr error: %v", err)
+	}
+
+	if _, err = pool.AllocateContiguous(129, 1); !errors.Is(err, ErrPoolExhausted) {
+		t.Errorf("AllocateContiguous(129, 1) err = %v; want ErrPoolExhausted", err)
+	}
+	if _, err = pool.AllocateContiguous(128, 128); err != nil {
+		t.Errorf("AllocateContiguous(128, 128) error: %v", err)
+	}
+	if _, err = pool.AllocateContiguous(128, 1); !errors.Is(err, ErrPoolExhausted) {
+		t.Errorf("AllocateContiguous(128, 1) err = %v; want ErrPoolExhausted", err)
+	}
+	if n := pool.allocated(); n != 129 {
+		t.Errorf("allocated() = %d; want 129", n)
+	}
+	for _, c := range [][2]uint64{{0, 1}, {4, 0}, {4, 3}} {
+		if _, err = pool.AllocateContiguous(c[0], c[1]); !errors.Is(err, ErrInvalidConfig) {
+			t.Errorf("AllocateContiguous(%d, %d) err = %v; want ErrInvalidConfig", c[0], c[1], err)
+		}
+	}
+}
+
+// TestReleaseContiguous ensures a range is released whole, or not at all
+func TestReleaseContiguous(t *testing.T) {
+	pool, err := NewPool("2001:db8::", 64, 120, 1)
+	if err != nil {
+		t.Fatalf("NewPool error: %v", err)
+	}
+	r, err := pool.AllocateContiguous(300, 256)
+	if err != nil {
+		t.Fatalf("AllocateContiguous error: %v", err)
+	}
+	if err = pool.ReleaseAddr(r.Last()); err != nil {
+		t.Fatalf("ReleaseAddr error: %v", err)
+	}
+
+	var addrErr *AddressError
+	if err = pool.ReleaseContiguous(r); !errors.As(err, &addrErr) || addrErr.Addr != r.Last() {
+		t.Fatalf("ReleaseContiguous with a released address err = %v; want the *AddressError of %s", err, r.Last())
+	}
+	if n := pool.allocated(); n != 299 {
+		t.Errorf("allocated() after failed ReleaseContiguous = %d; want 299", n)
+	}
+
+	r.Count--
+	if err = pool.ReleaseContiguous(r); err != nil {
+		t.Errorf("ReleaseContiguous error: %v", err)
+	}
+	if n := pool.allocated(); n != 0 {
+		t.Errorf("allocated() after ReleaseContiguous = %d; want 0", n)
+	}
+	if errVerify := pool.Verify(); errVerify != nil {
+		t.Errorf("Verify error: %v", errVerify)
+	}
+}
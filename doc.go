@@ -1,4 +1,4 @@
-// Package cidrx provides a simple IPv6 address pool allocator.
+// Package cidrx provides a simple IPv6 (and IPv4) address pool allocator.
 // It partitions a base IPv6 network (for example 2001:db8::/64) into fixed-size bitmap blocks
 // (for example /120) and offers O(1) Allocate and Release methods with minimal heap allocations.
 // The pool supports in-memory snapshot and restore operations.
@@ -0,0 +1,165 @@
+package cidrx
+
+import (
+	"errors"
+	"fmt"
+	"net"
+)
+
+// DualStackPool hands out IPv4 and IPv6 addresses in pairs, for workloads that always need one of each. A pair is
+// allocated and released as a unit: either both addresses change state or neither does, so callers never have to
+// roll back half of a failed allocation themselves.
+//
+// Both pools are locked together for every pair operation, so a joint Snapshot never sees half a pair. The pools may
+// still be used on their own, for example to Reserve well-known addresses.
+type DualStackPool struct {
+	v4 *Pool
+	v6 *Pool
+}
+
+// DualStackSnapshot is the state of both pools of a DualStackPool, taken at the same instant.
+type DualStackSnapshot struct {
+	IPv4 *Snapshot
+	IPv6 *Snapshot
+}
+
+// NewDualStackPool pairs an IPv4 pool with an IPv6 pool.
+func NewDualStackPool(v4, v6 *Pool) (*DualStackPool, error) {
+	if v4 == nil || !v4.ipv4 {
+		return nil, fmt.Errorf("%w: dual-stack pool needs an IPv4 pool as first argument", ErrInvalidConfig)
+	}
+	if v6 == nil || v6.ipv4 {
+		return nil, fmt.Errorf("%w: dual-stack pool needs an IPv6 pool as second argument", ErrInvalidConfig)
+	}
+	return &DualStackPool{v4: v4, v6: v6}, nil
+}
+
+// NewDualStackPoolFromSnapshot rebuilds a DualStackPool from a DualStackSnapshot. The options are applied to both
+// pools.
+func NewDualStackPoolFromSnapshot(s *DualStackSnapshot, opts ...Option) (*DualStackPool, error) {
+	if s.IPv4 == nil || s.IPv6 == nil {
+		return nil, fmt.Errorf("%w: dual-stack snapshot needs both families", ErrSnapshotCorrupt)
+	}
+
+	v4, err := NewPoolFromSnapshot(s.IPv4, opts...)
+	if err != nil {
+		return nil, err
+	}
+	v6, err := NewPoolFromSnapshot(s.IPv6, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return NewDualStackPool(v4, v6)
+}
+
+// IPv4 returns the IPv4 pool.
+func (d *DualStackPool) IPv4() *Pool {
+	return d.v4
+}
+
+// IPv6 returns the IPv6 pool.
+func (d *DualStackPool) IPv6() *Pool {
+	return d.v6
+}
+
+// Allocate returns a free IPv4 and a free IPv6, or neither. When one family runs out the error is ErrIPv4Exhausted or
+// ErrIPv6Exhausted, both of which also match ErrPoolExhausted.
+func (d *DualStackPool) Allocate() (net.IP, net.IP, error) {
+	d.lock()
+	defer d.unlock()
+
+	bi4, idx4, err := d.v4.allocate()
+	if err != nil {
+		return nil, nil, familyError(ErrIPv4Exhausted, err)
+	}
+	bi6, idx6, err := d.v6.allocate()
+	if err != nil {
+		_ = d.v4.free(bi4, idx4)
+		return nil, nil, familyError(ErrIPv6Exhausted, err)
+	}
+
+	ip4 := d.v4.blocks[bi4].bitToIP(idx4)
+	ip6 := d.v6.blocks[bi6].bitToIP(idx6)
+
+	// Record both before handing anything out, undoing the recorded half if the second one fails
+	if errJournal := d.v4.record(journalAllocate, fromIP(ip4)); errJournal != nil {
+		_ = d.v4.free(bi4, idx4)
+		_ = d.v6.free(bi6, idx6)
+		return nil, nil, errJournal
+	}
+	if errJournal := d.v6.record(journalAllocate, fromIP(ip6)); errJournal != nil {
+		_ = d.v6.free(bi6, idx6)
+		if errUndo := d.v4.freeRecorded(bi4, idx4, fromIP(ip4)); errUndo != nil {
+			return nil, nil, errors.Join(errJournal, errUndo)
+		}
+		return nil, nil, errJournal
+	}
+
+	return d.v4.outIP(ip4), ip6, nil
+}
+
+// Release frees a pair obtained from Allocate. Both addresses must be allocated, otherwise neither is released.
+func (d *DualStackPool) Release(ip4, ip6 net.IP) error {
+	u4, ok := d.v4.inIP(ip4)
+	if !ok {
+		return notInPool("release", ip4)
+	}
+	u6, ok := d.v6.inIP(ip6)
+	if !ok {
+		return notInPool("release", ip6)
+	}
+
+	d.lock()
+	defer d.unlock()
+
+	// Check both halves before touching either
+	bi4, idx4, err := d.v4.releasable(u4)
+	if err != nil {
+		return d.v4.addressError("release", u4, err)
+	}
+	bi6, idx6, err := d.v6.releasable(u6)
+	if err != nil {
+		return d.v6.addressError("release", u6, err)
+	}
+
+	if errRelease := d.v4.release(bi4, idx4, u4); errRelease != nil {
+		return d.v4.addressError("release", u4, errRelease)
+	}
+	if errRelease := d.v6.release(bi6, idx6, u6); errRelease != nil {
+		// Only the journal can fail here, take the IPv4 half back so the pair stays whole
+		errRelease = d.v6.addressError("release", u6, errRelease)
+		if errUndo := d.v4.reserve(u4); errUndo != nil {
+			return errors.Join(errRelease, d.v4.addressError("reserve", u4, errUndo))
+		}
+		return errRelease
+	}
+	return nil
+}
+
+// Snapshot returns the state of both pools, taken while both are locked.
+func (d *DualStackPool) Snapshot() *DualStackSnapshot {
+	d.lock()
+	defer d.unlock()
+
+	return &DualStackSnapshot{IPv4: d.v4.snapshotLocked(), IPv6: d.v6.snapshotLocked()}
+}
+
+// lock takes the locks of both pools, always IPv4 first
+func (d *DualStackPool) lock() {
+	d.v4.mu.Lock()
+	d.v6.mu.Lock()
+}
+
+// unlock releases the locks taken by lock
+func (d *DualStackPool) unlock() {
+	d.v6.mu.Unlock()
+	d.v4.mu.Unlock()
+}
+
+// familyError replaces ErrPoolExhausted with the per-family sentinel, leaving other errors untouched
+func familyError(exhausted, err error) error {
+	if errors.Is(err, ErrPoolExhausted) {
+		return exhausted
+	}
+	return err
+}
@@ -0,0 +1,155 @@
+package cidrx //nolint:testpackage // it's OK to be just cidrx
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// TestNewDualStackPoolFamilies ensures each side must hold the right address family
+func TestNewDualStackPoolFamilies(t *testing.T) {
+	v4, _ := NewPool("192.0.2.0", 24, 28, 1)
+	v6, _ := NewPool("2001:db8::", 64, 120, 1)
+	for _, pair := range [][2]*Pool{{v6, v4}, {v4, v4}, {v6, v6}, {nil, v6}, {v4, nil}} {
+		if _, err := NewDualStackPool(pair[0], pair[1]); err == nil {
+			t.Errorf("NewDualStackPool(%v, %v): expected error, got nil", pair[0], pair[1])
+		}
+	}
+}
+
+// TestDualStackAllocate ensures pairs come back with one address of each family
+func TestDualStackAllocate(t *testing.T) {
+	v4, _ := NewPool("192.0.2.0", 24, 32, 1)
+	v6, _ := NewPool("2001:db8::", 64, 120, 1)
+	d, _ := NewDualStackPool(v4, v6)
+	ip4, ip6, err := d.Allocate()
+	if err != nil {
+		t.Fatalf("Allocate error: %v", err)
+	}
+	if len(ip4) != net.IPv4len || !ip4.Equal(net.ParseIP("192.0.2.0")) {
+		t.Errorf("IPv4 = %v; want 4-byte 192.0.2.0", ip4)
+	}
+	if !ip6.Equal(net.ParseIP("2001:db8::")) {
+		t.Errorf("IPv6 = %v; want 2001:db8::", ip6)
+	}
+}
+
+// TestDualStackExhaustion ensures a pair is all or nothing when one family runs out
+func TestDualStackExhaustion(t *testing.T) {
+	// /31 => only two IPv4 addresses
+	v4, _ := NewPool("192.0.2.0", 31, 32, 1)
+	v6, _ := NewPool("2001:db8::", 64, 120, 1)
+	d, _ := NewDualStackPool(v4, v6)
+	for i := 0; i < 2; i++ {
+		if _, _, err := d.Allocate(); err != nil {
+			t.Fatalf("Allocate #%d error: %v", i, err)
+		}
+	}
+
+	_, _, err := d.Allocate()
+	if !errors.Is(err, ErrIPv4Exhausted) || !errors.Is(err, ErrPoolExhausted) {
+		t.Fatalf("Allocate with IPv4 exhausted err = %v; want ErrIPv4Exhausted", err)
+	}
+	// No IPv6 address leaked with the failed pair
+	ip6, _ := d.IPv6().Allocate()
+	if !ip6.Equal(net.ParseIP("2001:db8::2")) {
+		t.Errorf("next IPv6 = %v; want 2001:db8::2", ip6)
+	}
+
+	// Exhaust the IPv6 side while IPv4 still has room
+	v4, _ = NewPool("10.0.0.0", 24, 32, 1)
+	v6, _ = NewPool("2001:db8::", 127, 128, 1)
+	d, _ = NewDualStackPool(v4, v6)
+	for i := 0; i < 2; i++ {
+		_, _, _ = d.Allocate()
+	}
+	if _, _, errAllocate := d.Allocate(); !errors.Is(errAllocate, ErrIPv6Exhausted) {
+		t.Fatalf("Allocate with IPv6 exhausted err = %v; want ErrIPv6Exhausted", errAllocate)
+	}
+	if ip4, _ := v4.Allocate(); !ip4.Equal(net.ParseIP("10.0.0.2")) {
+		t.Errorf("next IPv4 = %v; want 10.0.0.2, the failed pair must not keep one", ip4)
+	}
+}
+
+// TestDualStackRelease ensures a pair is only released when both halves are allocated
+func TestDualStackRelease(t *testing.T) {
+	v4, _ := NewPool("192.0.2.0", 24, 32, 1)
+	v6, _ := NewPool("2001:db8::", 64, 120, 1)
+	d, _ := NewDualStackPool(v4, v6)
+	ip4, ip6, _ := d.Allocate()
+	other4, other6, _ := d.Allocate()
+
+	// Free one half behind the pool's back, the joint release must then leave the other half alone
+	if err := d.IPv6().Release(other6); err != nil {
+		t.Fatalf("Release IPv6 error: %v", err)
+	}
+	if err := d.Release(other4, other6); !errors.Is(err, ErrNotAllocated) {
+		t.Errorf("Release of half-free pair err = %v; want ErrNotAllocated", err)
+	}
+	if err := d.IPv4().Release(other4); err != nil {
+		t.Errorf("IPv4 half released by failed joint release: %v", err)
+	}
+
+	if err := d.Release(ip6, ip4); err == nil {
+		t.Error("expected error releasing a pair with swapped families, got nil")
+	}
+	if err := d.Release(ip4, ip6); err != nil {
+		t.Fatalf("Release error: %v", err)
+	}
+	if err := d.Release(ip4, ip6); err == nil {
+		t.Error("expected error releasing a pair twice, got nil")
+	}
+}
+
+// TestDualStackReleaseExcluded ensures an excluded half fails the joint release before the other half is touched
+func TestDualStackReleaseExcluded(t *testing.T) {
+	v4, _ := NewPool("192.0.2.0", 24, 32, 1)
+	excluded := netip.MustParseAddr("2001:db8::ff")
+	v6, _ := NewPool("2001:db8::", 64, 120, 1, WithExcludedPrefixes(netip.PrefixFrom(excluded, 128)))
+	d, err := NewDualStackPool(v4, v6)
+	if err != nil {
+		t.Fatalf("NewDualStackPool error: %v", err)
+	}
+	ip4, _, err := v4.AllocateLease(time.Hour)
+	if err != nil {
+		t.Fatalf("AllocateLease error: %v", err)
+	}
+	// Creates the IPv6 block, whose bitmap marks the excluded address as used
+	if _, err = v6.Allocate(); err != nil {
+		t.Fatalf("Allocate IPv6 error: %v", err)
+	}
+
+	if err = d.Release(ip4, excluded.AsSlice()); !errors.Is(err, ErrExcluded) {
+		t.Errorf("Release with an excluded IPv6 half err = %v; want ErrExcluded", err)
+	}
+	if !v4.IsAllocated(ip4) || len(v4.Snapshot().Leases) != 1 {
+		t.Errorf("IPv4 half or its lease lost by the failed joint release")
+	}
+}
+
+// TestDualStackSnapshot ensures the joint snapshot restores both families
+func TestDualStackSnapshot(t *testing.T) {
+	v4, _ := NewPool("192.0.2.0", 24, 32, 1)
+	v6, _ := NewPool("2001:db8::", 64, 120, 1)
+	d, _ := NewDualStackPool(v4, v6)
+	var pairs [][2]net.IP
+	for i := 0; i < 10; i++ {
+		ip4, ip6, _ := d.Allocate()
+		pairs = append(pairs, [2]net.IP{ip4, ip6})
+	}
+
+	restored, err := NewDualStackPoolFromSnapshot(d.Snapshot())
+	if err != nil {
+		t.Fatalf("NewDualStackPoolFromSnapshot error: %v", err)
+	}
+	for _, pair := range pairs {
+		if errRelease := restored.Release(pair[0], pair[1]); errRelease != nil {
+			t.Errorf("Release %v after restore: %v", pair, errRelease)
+		}
+	}
+	if _, errRestore := NewDualStackPoolFromSnapshot(&DualStackSnapshot{IPv4: d.Snapshot().IPv4}); errRestore == nil {
+		t.Error("expected error restoring a snapshot without IPv6, got nil")
+	}
+}
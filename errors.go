@@ -1,6 +1,11 @@
 package cidrx
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+)
 
 var (
 	// ErrBlockFull indicates no free addresses remain in the block
@@ -9,4 +14,77 @@ var (
 	ErrOutOfRange = errors.New("IP offset out of range")
 	// ErrNotAllocated indicates an attempt to release an IP that wasn't allocated
 	ErrNotAllocated = errors.New("IP not allocated")
+	// ErrAlreadyAllocated indicates an attempt to claim an IP that is already in use
+	ErrAlreadyAllocated = errors.New("IP already allocated")
+	// ErrNotInPool indicates the IP lies outside the network managed by the pool
+	ErrNotInPool = errors.New("IP not in pool")
+	// ErrExcluded indicates the IP lies in a range excluded from the pool, it is never handed out nor released
+	ErrExcluded = errors.New("IP excluded from pool")
+	// ErrInvalidPrefix indicates a network, prefix or prefix length that can't be used
+	ErrInvalidPrefix = errors.New("invalid prefix")
+	// ErrInvalidConfig indicates an option or argument, other than a prefix, that can't be used
+	ErrInvalidConfig = errors.New("invalid configuration")
+	// ErrPoolExhausted indicates there is no free space left to satisfy the allocation
+	ErrPoolExhausted = errors.New("pool exhausted")
+	// ErrIPv4Exhausted indicates the IPv4 side of a dual-stack pool is exhausted, it matches ErrPoolExhausted too
+	ErrIPv4Exhausted = fmt.Errorf("IPv4 %w", ErrPoolExhausted)
+	// ErrIPv6Exhausted indicates the IPv6 side of a dual-stack pool is exhausted, it matches ErrPoolExhausted too
+	ErrIPv6Exhausted = fmt.Errorf("IPv6 %w", ErrPoolExhausted)
+	// ErrLeaseNotFound indicates the lease was never granted, or was already released or reaped
+	ErrLeaseNotFound = errors.New("lease not found")
+	// ErrLeaseExpired indicates the lease expired and is waiting to be reaped
+	ErrLeaseExpired = errors.New("lease expired")
+	// ErrPrefixOverlap indicates a network overlaps one already managed alongside it
+	ErrPrefixOverlap = errors.New("prefix overlaps")
+	// ErrRangeInUse indicates an attempt to remove a range that is not drained or still holds allocated addresses
+	ErrRangeInUse = errors.New("range in use")
+	// ErrPrefixNotDelegated indicates an attempt to release a prefix that wasn't delegated
+	ErrPrefixNotDelegated = errors.New("prefix not delegated")
+	// ErrInconsistent indicates Verify found the pool state breaking one of its invariants
+	ErrInconsistent = errors.New("pool state inconsistent")
+
+	// ErrSnapshotCorrupt indicates the encoded snapshot is malformed or describes an inconsistent pool
+	ErrSnapshotCorrupt = errors.New("snapshot corrupt")
+	// ErrSnapshotTruncated indicates the encoded snapshot ends before its declared length
+	ErrSnapshotTruncated = errors.New("snapshot truncated")
+	// ErrSnapshotChecksum indicates the encoded snapshot does not match its checksum
+	ErrSnapshotChecksum = errors.New("snapshot checksum mismatch")
+	// ErrSnapshotVersion indicates the encoded snapshot uses a format version this package cannot decode
+	ErrSnapshotVersion = errors.New("unsupported snapshot version")
+
+	// ErrJournalCorrupt indicates a journal file holds records that can't be replayed
+	ErrJournalCorrupt = errors.New("journal corrupt")
+	// ErrJournalClosed indicates the journal was closed and no longer records pool mutations
+	ErrJournalClosed = errors.New("journal closed")
+	// ErrJournalFailed indicates a journal record could not be written or undone, so the log can no longer be trusted
+	ErrJournalFailed = errors.New("journal failed")
 )
+
+// AddressError records an operation that failed on a specific address. Err is one of the sentinel errors above, or the
+// journal error that stopped the operation, and is matched by errors.Is through Unwrap.
+type AddressError struct {
+	Op    string     // operation that failed, e.g. "release"
+	Addr  netip.Addr // address given to the operation, unmapped for IPv4 pools; invalid if none was given
+	Block uint64     // index of the block holding Addr, only set when Addr lies in the pool network
+	Err   error
+}
+
+func (e *AddressError) Error() string {
+	return fmt.Sprintf("%s %s: %v", e.Op, e.Addr, e.Err)
+}
+
+func (e *AddressError) Unwrap() error {
+	return e.Err
+}
+
+// addressError wraps err, returned by op on address u, into an AddressError
+func (p *Pool) addressError(op string, u Uint128, err error) error {
+	bi, _ := p.blockOf(u)
+	return &AddressError{Op: op, Addr: p.outAddr(u.toAddr()), Block: bi, Err: err}
+}
+
+// notInPool returns the AddressError of op called with ip, which is not of the pool address family
+func notInPool(op string, ip net.IP) error {
+	addr, _ := netip.AddrFromSlice(ip)
+	return &AddressError{Op: op, Addr: addr.Unmap(), Err: ErrNotInPool}
+}
@@ -0,0 +1,112 @@
+package cidrx //nolint:testpackage // it's OK to be just cidrx
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// TestAddressError ensures address failures carry the operation, the address and its block, and match their sentinel
+func TestAddressError(t *testing.T) {
+	pool, err := NewPool("10.0.0.0", 24, 28, 1)
+	if err != nil {
+		t.Fatalf("NewPool error: %v", err)
+	}
+	if errReserve := pool.Reserve(net.ParseIP("10.0.0.33")); errReserve != nil {
+		t.Fatalf("Reserve error: %v", errReserve)
+	}
+
+	cases := []struct {
+		name     string
+		err      error
+		op       string
+		addr     string
+		block    uint64
+		sentinel error
+		inPool   bool
+	}{
+		{"double reserve", pool.Reserve(net.ParseIP("10.0.0.33")), "reserve", "10.0.0.33", 2, ErrAlreadyAllocated, true},
+		{"unallocated", pool.ReleaseAddr(netip.MustParseAddr("10.0.0.34")), "release", "10.0.0.34", 2, ErrNotAllocated,
+			true},
+		{"uncreated block", pool.Release(net.ParseIP("10.0.0.200")), "release", "10.0.0.200", 12, ErrNotAllocated, true},
+		{"outside", pool.Release(net.ParseIP("10.0.1.1")), "release", "10.0.1.1", 0, ErrNotInPool, false},
+		{"other family", pool.ReserveAddr(netip.MustParseAddr("2001:db8::1")), "reserve", "2001:db8::1", 0,
+			ErrNotInPool, false},
+	}
+	for _, c := range cases {
+		var addrErr *AddressError
+		if !errors.As(c.err, &addrErr) {
+			t.Errorf("%s: err = %v; want an *AddressError", c.name, c.err)
+			continue
+		}
+		if !errors.Is(c.err, c.sentinel) {
+			t.Errorf("%s: err = %v; want %v", c.name, c.err, c.sentinel)
+		}
+		if addrErr.Op != c.op || addrErr.Addr != netip.MustParseAddr(c.addr) {
+			t.Errorf("%s: Op, Addr = %q, %s; want %q, %s", c.name, addrErr.Op, addrErr.Addr, c.op, c.addr)
+		}
+		if c.inPool && addrErr.Block != c.block {
+			t.Errorf("%s: Block = %d; want %d", c.name, addrErr.Block, c.block)
+		}
+	}
+}
+
+// TestAddressErrorSharded ensures a ShardedPool reports block indices within the whole network, not within a shard
+func TestAddressErrorSharded(t *testing.T) {
+	sp, err := NewShardedPool("2001:db8::", 64, 120, 1, 4)
+	if err != nil {
+		t.Fatalf("NewShardedPool error: %v", err)
+	}
+
+	// The last shard starts at block 2^54 * 3
+	errRelease := sp.Release(net.ParseIP("2001:db8::c000:0:0:501"))
+	var addrErr *AddressError
+	if !errors.As(errRelease, &addrErr) || !errors.Is(errRelease, ErrNotAllocated) {
+		t.Fatalf("Release err = %v; want an *AddressError wrapping ErrNotAllocated", errRelease)
+	}
+	if want := uint64(3)<<54 | 0x5; addrErr.Block != want {
+		t.Errorf("Block = %#x; want %#x", addrErr.Block, want)
+	}
+}
+
+// TestConfigErrors ensures invalid options and arguments match ErrInvalidConfig or the snapshot sentinels
+func TestConfigErrors(t *testing.T) {
+	pool, err := NewPool("2001:db8::", 64, 120, 1)
+	if err != nil {
+		t.Fatalf("NewPool error: %v", err)
+	}
+
+	if _, _, errLease := pool.AllocateLease(0); !errors.Is(errLease, ErrInvalidConfig) {
+		t.Errorf("AllocateLease(0) err = %v; want ErrInvalidConfig", errLease)
+	}
+	if _, errPool := NewPool("2001:db8::", 64, 120, 1, WithBlockReclaim(-time.Second)); !errors.Is(errPool,
+		ErrInvalidConfig) {
+		t.Errorf("WithBlockReclaim(-1s) err = %v; want ErrInvalidConfig", errPool)
+	}
+	if _, errPool := NewPool("10.0.0.0", 24, 28, 1, WithReservedAnycast()); !errors.Is(errPool, ErrInvalidConfig) {
+		t.Errorf("WithReservedAnycast on IPv4 err = %v; want ErrInvalidConfig", errPool)
+	}
+	if _, errDual := NewDualStackPool(pool, pool); !errors.Is(errDual, ErrInvalidConfig) {
+		t.Errorf("NewDualStackPool(v6, v6) err = %v; want ErrInvalidConfig", errDual)
+	}
+	if _, errShard := NewShardedPool("2001:db8::", 64, 120, 1, 3); !errors.Is(errShard, ErrInvalidConfig) {
+		t.Errorf("NewShardedPool with 3 shards err = %v; want ErrInvalidConfig", errShard)
+	}
+
+	if _, errSnap := NewPoolFromSnapshot(&Snapshot{}); !errors.Is(errSnap, ErrSnapshotCorrupt) {
+		t.Errorf("NewPoolFromSnapshot(empty) err = %v; want ErrSnapshotCorrupt", errSnap)
+	}
+	if _, errSnap := NewShardedPoolFromSnapshot(&Snapshot{}, 1); !errors.Is(errSnap, ErrSnapshotCorrupt) {
+		t.Errorf("NewShardedPoolFromSnapshot(empty) err = %v; want ErrSnapshotCorrupt", errSnap)
+	}
+
+	ps, err := NewPoolSet(pool)
+	if err != nil {
+		t.Fatalf("NewPoolSet error: %v", err)
+	}
+	if _, errRemove := ps.Remove(pool.Prefix()); !errors.Is(errRemove, ErrRangeInUse) {
+		t.Errorf("Remove of an undrained range err = %v; want ErrRangeInUse", errRemove)
+	}
+}
@@ -0,0 +1,231 @@
+package cidrx
+
+import (
+	"fmt"
+	"math/bits"
+	"net/netip"
+	"slices"
+)
+
+// rfc2526AnycastIDs is the number of reserved subnet anycast addresses at the top of an IPv6 subnet (RFC 2526)
+const rfc2526AnycastIDs = 128
+
+// AddrRange is an inclusive range of addresses, from First to Last.
+type AddrRange struct {
+	First netip.Addr
+	Last  netip.Addr
+}
+
+// addrRange is an inclusive range in the internal 128-bit representation
+type addrRange struct {
+	first, last Uint128
+}
+
+// contains reports whether u lies in r
+func (r addrRange) contains(u Uint128) bool {
+	return r.first.cmp(u) <= 0 && r.last.cmp(u) >= 0
+}
+
+// WithExcludedPrefixes keeps every address of the given prefixes from being handed out. Parts of a prefix outside
+// the pool network are ignored.
+func WithExcludedPrefixes(prefixes ...netip.Prefix) Option {
+	return func(p *Pool) {
+		for _, prefix := range prefixes {
+			if !prefix.IsValid() {
+				p.optErr = fmt.Errorf("%w: excluded prefix %s", ErrInvalidPrefix, prefix)
+				return
+			}
+			prefix = prefix.Masked()
+			last := fromAddr(prefix.Addr()).add(hostMask(uint(prefix.Addr().BitLen() - prefix.Bits())))
+			p.exclude(prefix.Addr(), p.outAddr(last.toAddr()), prefix.String())
+		}
+	}
+}
+
+// WithExcludedRanges keeps every address of the given ranges from being handed out. Parts of a range outside the
+// pool network are ignored.
+func WithExcludedRanges(ranges ...AddrRange) Option {
+	return func(p *Pool) {
+		for _, r := range ranges {
+			p.exclude(r.First, r.Last, fmt.Sprintf("%s-%s", r.First, r.Last))
+		}
+	}
+}
+
+// WithReservedAnycast excludes the addresses IPv6 reserves for anycast in the pool network: the Subnet-Router anycast
+// address (the first one, RFC 4291) and the 128 reserved subnet anycast addresses at the top (RFC 2526).
+func WithReservedAnycast() Option {
+	return func(p *Pool) {
+		if p.ipv4 {
+			p.optErr = fmt.Errorf("%w: reserved anycast addresses only exist in IPv6 networks", ErrInvalidConfig)
+			return
+		}
+
+		last := p.lastAddr()
+		top := p.networkAddr
+		if span := last.sub(p.networkAddr); span.Hi != 0 || span.Lo >= rfc2526AnycastIDs {
+			top = last.sub(Uint128{Lo: rfc2526AnycastIDs - 1})
+		}
+
+		p.excluded = append(p.excluded,
+			addrRange{first: p.networkAddr, last: p.networkAddr},
+			addrRange{first: top, last: last})
+	}
+}
+
+// lastAddr returns the last address of the pool network
+func (p *Pool) lastAddr() Uint128 {
+	ones, _ := p.blockMask.Size()
+	return p.networkAddr.add(hostMask(uint(ipv6BitLen - ones)))
+}
+
+// exclude adds the range first-last, given in caller form, to the excluded ranges
+func (p *Pool) exclude(first, last netip.Addr, what string) {
+	f, okFirst := p.inAddr(first)
+	l, okLast := p.inAddr(last)
+	if !okFirst || !okLast || f.cmp(l) > 0 {
+		p.optErr = fmt.Errorf("%w: excluded range %s does not fit this pool", ErrInvalidPrefix, what)
+		return
+	}
+	p.excluded = append(p.excluded, addrRange{first: f, last: l})
+}
+
+// applyExclusions normalizes the excluded ranges, clipping them to the pool network and merging overlapping or
+// adjacent ones, then marks them in the blocks that already exist. Must run once blocks are in place.
+func (p *Pool) applyExclusions() {
+	netLast := p.lastAddr()
+
+	slices.SortFunc(p.excluded, func(a, b addrRange) int { return a.first.cmp(b.first) })
+	merged := p.excluded[:0]
+	for _, r := range p.excluded {
+		// Clip to the network
+		if r.last.cmp(p.networkAddr) < 0 || r.first.cmp(netLast) > 0 {
+			continue
+		}
+		if r.first.cmp(p.networkAddr) < 0 {
+			r.first = p.networkAddr
+		}
+		if r.last.cmp(netLast) > 0 {
+			r.last = netLast
+		}
+
+		// Merge with the previous range when they overlap or touch
+		if n := len(merged); n > 0 && merged[n-1].last.cmp(netLast) < 0 &&
+			r.first.cmp(merged[n-1].last.add(Uint128{Lo: 1})) <= 0 {
+			if r.last.cmp(merged[n-1].last) > 0 {
+				merged[n-1].last = r.last
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	p.excluded = merged
+
+	for _, blk := range p.blocks {
+		p.markExcluded(blk)
+	}
+	p.advanceNextBlock()
+}
+
+// markExcluded sets the bits of every excluded address inside blk
+func (p *Pool) markExcluded(blk *block) {
+	p.excludedSpans(blk.base, blk.size, blk.excludeRange)
+}
+
+// excludedSpans calls fn with the first and last offset (inclusive) of each excluded range overlapping the size
+// addresses starting at base, in order
+func (p *Pool) excludedSpans(base Uint128, size uint64, fn func(from, to uint64)) {
+	last := base.add(Uint128{Lo: size - 1})
+
+	// First range that ends inside or after the span
+	i, _ := slices.BinarySearchFunc(p.excluded, base, func(r addrRange, u Uint128) int { return r.last.cmp(u) })
+	for ; i < len(p.excluded) && p.excluded[i].first.cmp(last) <= 0; i++ {
+		from, to := uint64(0), size-1
+		if r := p.excluded[i]; r.first.cmp(base) > 0 {
+			from = r.first.sub(base).Lo
+		}
+		if r := p.excluded[i]; r.last.cmp(last) < 0 {
+			to = r.last.sub(base).Lo
+		}
+		fn(from, to)
+	}
+}
+
+// checkExcludedFree returns an AddressError for the first address allocated in blocks that falls in one of ranges but
+// none of kept, the ranges already excluded when the bitmaps were taken
+func (p *Pool) checkExcludedFree(blocks map[uint64][]uint64, ranges, kept []addrRange) error {
+	for idx, words := range blocks {
+		base := p.networkAddr.add(Uint128{Lo: idx}.lsh(p.hostBits))
+		last := base.add(Uint128{Lo: p.blockSize - 1})
+		for _, r := range ranges {
+			if r.last.cmp(base) < 0 || r.first.cmp(last) > 0 {
+				continue
+			}
+			from, to := uint64(0), p.blockSize-1
+			if r.first.cmp(base) > 0 {
+				from = r.first.sub(base).Lo
+			}
+			if r.last.cmp(last) < 0 {
+				to = r.last.sub(base).Lo
+			}
+
+			for wi := from / 64; wi <= to/64 && wi < uint64(len(words)); wi++ {
+				for set := words[wi] & spanMask(wi, from, to); set != 0; set &= set - 1 {
+					u := base.add(Uint128{Lo: wi*64 + uint64(bits.TrailingZeros64(set))})
+					if !slices.ContainsFunc(kept, func(k addrRange) bool { return k.contains(u) }) {
+						return p.addressError("exclude", u, ErrAlreadyAllocated)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// isExcluded reports whether u lies in an excluded range
+func (p *Pool) isExcluded(u Uint128) bool {
+	i, _ := slices.BinarySearchFunc(p.excluded, u, func(r addrRange, u Uint128) int { return r.last.cmp(u) })
+	return i < len(p.excluded) && p.excluded[i].first.cmp(u) <= 0
+}
+
+// skipExcluded returns the first block index at or after bi that is not entirely excluded, so Allocate never creates
+// blocks it can't hand anything out of
+func (p *Pool) skipExcluded(bi uint64) uint64 {
+	for bi < p.maxBlocks {
+		base := p.networkAddr.add(Uint128{Lo: bi}.lsh(p.hostBits))
+		i, _ := slices.BinarySearchFunc(p.excluded, base, func(r addrRange, u Uint128) int { return r.last.cmp(u) })
+		if i == len(p.excluded) || p.excluded[i].first.cmp(base) > 0 {
+			return bi
+		}
+
+		// The range starts at or before the block, jump to the block holding its end
+		lastBi, _ := p.blockOf(p.excluded[i].last)
+		lastBase := p.networkAddr.add(Uint128{Lo: lastBi}.lsh(p.hostBits))
+		if p.excluded[i].last.sub(lastBase).Lo != p.blockSize-1 {
+			// Ranges are merged, so that block is only partly excluded
+			return lastBi
+		}
+		bi = lastBi + 1
+	}
+	return bi
+}
+
+// advanceNextBlock moves nextBlockIndex past blocks that already exist or are entirely excluded
+func (p *Pool) advanceNextBlock() {
+	for {
+		p.nextBlockIndex = p.skipExcluded(p.nextBlockIndex)
+		if p.nextBlockIndex >= p.maxBlocks || p.blocks[p.nextBlockIndex] == nil {
+			return
+		}
+		p.nextBlockIndex++
+	}
+}
+
+// excludedRanges returns the excluded ranges in caller form
+func (p *Pool) excludedRanges() []AddrRange {
+	out := make([]AddrRange, 0, len(p.excluded))
+	for _, r := range p.excluded {
+		out = append(out, AddrRange{First: p.outAddr(r.first.toAddr()), Last: p.outAddr(r.last.toAddr())})
+	}
+	return out
+}
@@ -0,0 +1,202 @@
+package cidrx //nolint:testpackage // it's OK to be just cidrx
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+)
+
+// TestReservedAnycast ensures the Subnet-Router anycast address and the top 128 addresses are never handed out
+func TestReservedAnycast(t *testing.T) {
+	// /120 with /121 blocks => 2 blocks of 128, the second one is entirely reserved
+	pool, err := NewPool("2001:db8::", 120, 121, 2, WithReservedAnycast())
+	if err != nil {
+		t.Fatalf("NewPool error: %v", err)
+	}
+
+	for i := 1; i < 128; i++ {
+		ip, errAllocate := pool.Allocate()
+		if errAllocate != nil {
+			t.Fatalf("Allocate #%d error: %v", i, errAllocate)
+		}
+		if want := netip.AddrFrom16([16]byte{0x20, 0x01, 0x0d, 0xb8, 15: byte(i)}); !ip.Equal(net.IP(want.AsSlice())) {
+			t.Fatalf("Allocate #%d = %v; want %v", i, ip, want)
+		}
+	}
+	if _, errAllocate := pool.Allocate(); !errors.Is(errAllocate, ErrPoolExhausted) {
+		t.Errorf("Allocate on full pool err = %v; want ErrPoolExhausted", errAllocate)
+	}
+	if n := pool.allocated(); n != 127 {
+		t.Errorf("allocated() = %d; want 127", n)
+	}
+	if len(pool.blocks) != 1 {
+		t.Errorf("created %d blocks; want 1, the reserved block must be skipped", len(pool.blocks))
+	}
+
+	if _, errNew := NewPool("10.0.0.0", 24, 28, 1, WithReservedAnycast()); errNew == nil {
+		t.Errorf("WithReservedAnycast on IPv4 pool: expected error, got nil")
+	}
+}
+
+// TestExcludedPrefixesAndRanges ensures excluded addresses are skipped by Allocate and refused by Reserve and Release
+func TestExcludedPrefixesAndRanges(t *testing.T) {
+	pool, err := NewPoolFromPrefix(netip.MustParsePrefix("2001:db8::/64"), 120, 4,
+		WithExcludedPrefixes(netip.MustParsePrefix("2001:db8::/126"), netip.MustParsePrefix("2001:db8:1::/64")),
+		WithExcludedRanges(AddrRange{First: netip.MustParseAddr("2001:db8::5"), Last: netip.MustParseAddr("2001:db8::6")}))
+	if err != nil {
+		t.Fatalf("NewPoolFromPrefix error: %v", err)
+	}
+
+	for _, want := range []string{"2001:db8::4", "2001:db8::7", "2001:db8::8"} {
+		addr, errAllocate := pool.AllocateAddr()
+		if errAllocate != nil {
+			t.Fatalf("AllocateAddr error: %v", errAllocate)
+		}
+		if addr != netip.MustParseAddr(want) {
+			t.Errorf("AllocateAddr = %s; want %s", addr, want)
+		}
+	}
+
+	excluded := netip.MustParseAddr("2001:db8::5")
+	if errReserve := pool.ReserveAddr(excluded); !errors.Is(errReserve, ErrExcluded) {
+		t.Errorf("ReserveAddr(%s) err = %v; want ErrExcluded", excluded, errReserve)
+	}
+	if errRelease := pool.ReleaseAddr(excluded); !errors.Is(errRelease, ErrExcluded) {
+		t.Errorf("ReleaseAddr(%s) err = %v; want ErrExcluded", excluded, errRelease)
+	}
+	if n := pool.allocated(); n != 3 {
+		t.Errorf("allocated() = %d; want 3", n)
+	}
+}
+
+// TestExcludedBlocksSkipped ensures Allocate jumps over blocks that are entirely excluded
+func TestExcludedBlocksSkipped(t *testing.T) {
+	// /28 with /30 blocks => 4 blocks of 4, the middle two are excluded
+	pool, err := NewPool("10.0.0.0", 28, 30, 4,
+		WithExcludedRanges(AddrRange{First: netip.MustParseAddr("10.0.0.4"), Last: netip.MustParseAddr("10.0.0.11")}))
+	if err != nil {
+		t.Fatalf("NewPool error: %v", err)
+	}
+
+	var got []string
+	for {
+		ip, errAllocate := pool.Allocate()
+		if errors.Is(errAllocate, ErrPoolExhausted) {
+			break
+		}
+		if errAllocate != nil {
+			t.Fatalf("Allocate error: %v", errAllocate)
+		}
+		got = append(got, ip.String())
+	}
+
+	want := []string{"10.0.0.0", "10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.12", "10.0.0.13", "10.0.0.14", "10.0.0.15"}
+	if len(got) != len(want) {
+		t.Fatalf("allocated %v; want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Allocate #%d = %s; want %s", i, got[i], want[i])
+		}
+	}
+	if len(pool.blocks) != 2 {
+		t.Errorf("created %d blocks; want 2", len(pool.blocks))
+	}
+}
+
+// TestExcludedSnapshotRoundTrip ensures excluded ranges survive a binary snapshot and keep being enforced
+func TestExcludedSnapshotRoundTrip(t *testing.T) {
+	pool, err := NewPool("10.0.0.0", 24, 26, 4,
+		WithExcludedPrefixes(netip.MustParsePrefix("10.0.0.0/30"), netip.MustParsePrefix("10.0.0.64/26")))
+	if err != nil {
+		t.Fatalf("NewPool error: %v", err)
+	}
+	if _, errAllocate := pool.Allocate(); errAllocate != nil {
+		t.Fatalf("Allocate error: %v", errAllocate)
+	}
+
+	data, err := pool.Snapshot().MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary error: %v", err)
+	}
+	var s Snapshot
+	if errUnmarshal := s.UnmarshalBinary(data); errUnmarshal != nil {
+		t.Fatalf("UnmarshalBinary error: %v", errUnmarshal)
+	}
+	if len(s.Excluded) != 2 {
+		t.Fatalf("snapshot holds %d excluded ranges; want 2", len(s.Excluded))
+	}
+
+	restored, err := NewPoolFromSnapshot(&s)
+	if err != nil {
+		t.Fatalf("NewPoolFromSnapshot error: %v", err)
+	}
+	if n := restored.allocated(); n != 1 {
+		t.Errorf("allocated() = %d; want 1", n)
+	}
+	if errReserve := restored.ReserveAddr(netip.MustParseAddr("10.0.0.70")); !errors.Is(errReserve, ErrExcluded) {
+		t.Errorf("ReserveAddr(10.0.0.70) err = %v; want ErrExcluded", errReserve)
+	}
+
+	// The excluded second block is skipped once the first one fills up
+	for i := 0; i < 59; i++ {
+		if _, errAllocate := restored.Allocate(); errAllocate != nil {
+			t.Fatalf("Allocate #%d error: %v", i, errAllocate)
+		}
+	}
+	ip, err := restored.Allocate()
+	if err != nil {
+		t.Fatalf("Allocate error: %v", err)
+	}
+	if want := net.IPv4(10, 0, 0, 128).To4(); !ip.Equal(want) {
+		t.Errorf("Allocate = %v; want %v", ip, want)
+	}
+}
+
+// TestExcludedOverRestoredAllocations ensures exclusions passed to NewPoolFromSnapshot can't take addresses the
+// snapshot holds as allocated, while the snapshot's own excluded ranges can be passed again
+func TestExcludedOverRestoredAllocations(t *testing.T) {
+	pool, _ := NewPool("2001:db8::", 64, 120, 1, WithReservedAnycast())
+	for i := 0; i < 4; i++ {
+		if _, err := pool.Allocate(); err != nil {
+			t.Fatalf("Allocate error: %v", err)
+		}
+	}
+	snap := pool.Snapshot()
+
+	// ::1-::4 are allocated
+	_, err := NewPoolFromSnapshot(snap, WithExcludedPrefixes(netip.MustParsePrefix("2001:db8::4/126")))
+	var addrErr *AddressError
+	if !errors.As(err, &addrErr) || !errors.Is(err, ErrAlreadyAllocated) ||
+		addrErr.Addr != netip.MustParseAddr("2001:db8::4") {
+		t.Errorf("NewPoolFromSnapshot excluding 2001:db8::4 err = %v; want ErrAlreadyAllocated on it", err)
+	}
+
+	restored, err := NewPoolFromSnapshot(snap, WithReservedAnycast(),
+		WithExcludedPrefixes(netip.MustParsePrefix("2001:db8::8/125")))
+	if err != nil {
+		t.Fatalf("NewPoolFromSnapshot with free exclusions error: %v", err)
+	}
+	if n := restored.allocated(); n != 4 {
+		t.Errorf("allocated() = %d; want 4", n)
+	}
+	if errVerify := restored.Verify(); errVerify != nil {
+		t.Errorf("Verify error: %v", errVerify)
+	}
+}
+
+// TestExcludedInvalid ensures exclusions of the wrong family or reversed ranges are rejected
+func TestExcludedInvalid(t *testing.T) {
+	for name, opt := range map[string]Option{
+		"wrong family": WithExcludedPrefixes(netip.MustParsePrefix("2001:db8::/64")),
+		"invalid":      WithExcludedPrefixes(netip.Prefix{}),
+		"reversed": WithExcludedRanges(AddrRange{
+			First: netip.MustParseAddr("10.0.0.9"), Last: netip.MustParseAddr("10.0.0.1"),
+		}),
+	} {
+		if _, err := NewPool("10.0.0.0", 24, 28, 1, opt); err == nil {
+			t.Errorf("%s: expected error, got nil", name)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package cidrx
+
+// The freeList lists every block that has a free address exactly once, and no other block. Each block knows whether
+// and where it is listed, so a block is added or removed in O(1) whenever its free count crosses zero.
+
+// relist lists blk when it has free addresses and unlists it when it has none, it must run after every change to the
+// bitmap of an existing block
+func (p *Pool) relist(bi uint64, blk *block) {
+	switch {
+	case blk.freeCount > 0 && !blk.listed:
+		blk.listed = true
+		blk.listPos = len(p.freeList)
+		p.freeList = append(p.freeList, bi)
+	case blk.freeCount == 0 && blk.listed:
+		p.unlist(blk)
+	}
+}
+
+// unlist removes blk from the freeList, moving the last entry into its place
+func (p *Pool) unlist(blk *block) {
+	if !blk.listed {
+		return
+	}
+
+	last := len(p.freeList) - 1
+	if moved := p.freeList[last]; blk.listPos != last {
+		p.freeList[blk.listPos] = moved
+		p.blocks[moved].listPos = blk.listPos
+	}
+	p.freeList = p.freeList[:last]
+	blk.listed = false
+}
@@ -0,0 +1,51 @@
+package cidrx
+
+import (
+	"net"
+	"net/netip"
+)
+
+// IPv4 pools run on the same 128-bit engine as IPv6 ones: the network is moved into the IPv4-mapped range
+// ::ffff:0:0/96, where the low 32 bits are the IPv4 address, so blocks, bitmaps and snapshots are shared. Addresses
+// are only converted at the API boundary, see inIP/inAddr and outIP/outAddr.
+const (
+	ipv4BitLen     = net.IPv4len * 8
+	ipv4MappedBits = ipv6BitLen - ipv4BitLen
+)
+
+// inIP converts ip into the internal representation, reporting false if it is not of the pool address family
+func (p *Pool) inIP(ip net.IP) (Uint128, bool) {
+	if ip.To16() == nil || (ip.To4() != nil) != p.ipv4 {
+		return Uint128{}, false
+	}
+	return fromIP(ip), true
+}
+
+// inAddr converts addr into the internal representation, reporting false if it is not of the pool address family.
+// IPv4 pools accept both plain and IPv4-mapped addresses, IPv6 pools reject IPv4-mapped ones.
+func (p *Pool) inAddr(addr netip.Addr) (Uint128, bool) {
+	if p.ipv4 {
+		if !addr.Unmap().Is4() {
+			return Uint128{}, false
+		}
+	} else if !addr.Is6() || addr.Is4In6() {
+		return Uint128{}, false
+	}
+	return fromAddr(addr), true
+}
+
+// outIP returns ip as handed to callers: 4 bytes long for IPv4 pools
+func (p *Pool) outIP(ip net.IP) net.IP {
+	if p.ipv4 {
+		return ip.To4()
+	}
+	return ip
+}
+
+// outAddr returns addr as handed to callers: unmapped for IPv4 pools
+func (p *Pool) outAddr(addr netip.Addr) netip.Addr {
+	if p.ipv4 {
+		return addr.Unmap()
+	}
+	return addr
+}
@@ -0,0 +1,165 @@
+package cidrx //nolint:testpackage // it's OK to be just cidrx
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+)
+
+// TestIPv4AllocateRelease ensures IPv4 pools hand out 4-byte addresses in order and take them back in either form
+func TestIPv4AllocateRelease(t *testing.T) {
+	pool, err := NewPool("10.1.2.0", 24, 28, 16)
+	if err != nil {
+		t.Fatalf("NewPool error: %v", err)
+	}
+
+	for i := 0; i < 20; i++ {
+		ip, errAllocate := pool.Allocate()
+		if errAllocate != nil {
+			t.Fatalf("Allocate #%d error: %v", i, errAllocate)
+		}
+		if len(ip) != net.IPv4len {
+			t.Fatalf("Allocate #%d returned %d bytes; want 4", i, len(ip))
+		}
+		if want := net.IPv4(10, 1, 2, byte(i)).To4(); !ip.Equal(want) {
+			t.Errorf("Allocate #%d = %v; want %v", i, ip, want)
+		}
+	}
+
+	// The 16-byte form returned by net.ParseIP is the same address
+	if errRelease := pool.Release(net.ParseIP("10.1.2.3")); errRelease != nil {
+		t.Errorf("Release 16-byte form error: %v", errRelease)
+	}
+	if errRelease := pool.Release(net.IPv4(10, 1, 2, 4).To4()); errRelease != nil {
+		t.Errorf("Release 4-byte form error: %v", errRelease)
+	}
+	for _, s := range []string{"10.1.3.0", "::a01:203", "2001:db8::"} {
+		if errRelease := pool.Release(net.ParseIP(s)); errRelease == nil {
+			t.Errorf("Release(%s): expected error, got nil", s)
+		}
+	}
+}
+
+// TestIPv4Exhaustion ensures an IPv4 pool is exhausted once every address of the network is taken
+func TestIPv4Exhaustion(t *testing.T) {
+	// /30 with /32 blocks => 4 addresses, one block each
+	pool, err := NewPool("192.0.2.4", 30, 32, 4)
+	if err != nil {
+		t.Fatalf("NewPool error: %v", err)
+	}
+	for i := 0; i < 4; i++ {
+		if _, errAllocate := pool.Allocate(); errAllocate != nil {
+			t.Fatalf("Allocate #%d error: %v", i, errAllocate)
+		}
+	}
+	if _, errAllocate := pool.Allocate(); !errors.Is(errAllocate, ErrPoolExhausted) {
+		t.Errorf("Allocate on full pool err = %v; want ErrPoolExhausted", errAllocate)
+	}
+}
+
+// TestIPv4InvalidParams ensures prefix lengths are checked against the 32-bit address space
+func TestIPv4InvalidParams(t *testing.T) {
+	for _, c := range []struct{ netPrefix, blk int }{
+		{33, 34},
+		{-1, 24},
+		{24, 24},
+		{24, 120},
+	} {
+		if _, err := NewPool("10.0.0.0", c.netPrefix, c.blk, 1); err == nil {
+			t.Errorf("NewPool(10.0.0.0, %d, %d): expected error, got nil", c.netPrefix, c.blk)
+		}
+	}
+}
+
+// TestIPv4Netip ensures the netip API works on IPv4 pools with unmapped addresses
+func TestIPv4Netip(t *testing.T) {
+	pool, err := NewPoolFromPrefix(netip.MustParsePrefix("172.16.0.0/12"), 24, 4)
+	if err != nil {
+		t.Fatalf("NewPoolFromPrefix error: %v", err)
+	}
+	if got := pool.Prefix(); got != netip.MustParsePrefix("172.16.0.0/12") {
+		t.Errorf("Prefix() = %s; want 172.16.0.0/12", got)
+	}
+
+	addr, err := pool.AllocateAddr()
+	if err != nil || addr != netip.MustParseAddr("172.16.0.0") {
+		t.Fatalf("AllocateAddr = %s, %v; want 172.16.0.0", addr, err)
+	}
+
+	cases := map[string]bool{
+		"172.16.0.0":        true,
+		"172.31.255.255":    true,
+		"::ffff:172.20.1.1": true,
+		"172.32.0.0":        false,
+		"2001:db8::":        false,
+	}
+	for s, want := range cases {
+		if got := pool.Contains(netip.MustParseAddr(s)); got != want {
+			t.Errorf("Contains(%s) = %v; want %v", s, got, want)
+		}
+	}
+
+	if errReserve := pool.ReserveAddr(netip.MustParseAddr("172.16.0.1")); errReserve != nil {
+		t.Fatalf("ReserveAddr error: %v", errReserve)
+	}
+	if errReserve := pool.Reserve(net.ParseIP("172.16.0.1")); !errors.Is(errReserve, ErrAlreadyAllocated) {
+		t.Errorf("Reserve of reserved IP err = %v; want ErrAlreadyAllocated", errReserve)
+	}
+	if next, _ := pool.AllocateAddr(); next != netip.MustParseAddr("172.16.0.2") {
+		t.Errorf("AllocateAddr after reservation = %s; want 172.16.0.2", next)
+	}
+	if errRelease := pool.ReleaseAddr(addr); errRelease != nil {
+		t.Errorf("ReleaseAddr error: %v", errRelease)
+	}
+}
+
+// TestIPv4Snapshot ensures an IPv4 pool survives a binary snapshot round trip and stays IPv4
+func TestIPv4Snapshot(t *testing.T) {
+	pool, _ := NewPool("10.0.0.0", 16, 24, 4)
+	held := make([]net.IP, 300)
+	for i := range held {
+		held[i], _ = pool.Allocate()
+	}
+
+	data, err := pool.Snapshot().MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary error: %v", err)
+	}
+	var snap Snapshot
+	if errDecode := snap.UnmarshalBinary(data); errDecode != nil {
+		t.Fatalf("UnmarshalBinary error: %v", errDecode)
+	}
+	restored, err := NewPoolFromSnapshot(&snap)
+	if err != nil {
+		t.Fatalf("NewPoolFromSnapshot error: %v", err)
+	}
+
+	ip, err := restored.Allocate()
+	if err != nil || !ip.Equal(net.ParseIP("10.0.1.44")) || len(ip) != net.IPv4len {
+		t.Errorf("Allocate after restore = %v, %v; want 4-byte 10.0.1.44", ip, err)
+	}
+	for _, h := range held {
+		if errRelease := restored.Release(h); errRelease != nil {
+			t.Errorf("Release %v after restore: %v", h, errRelease)
+		}
+	}
+}
+
+// TestIPv4ShardedPool ensures sharding splits an IPv4 network like an IPv6 one
+func TestIPv4ShardedPool(t *testing.T) {
+	sp, err := NewShardedPool("10.0.0.0", 8, 24, 0, 4)
+	if err != nil {
+		t.Fatalf("NewShardedPool error: %v", err)
+	}
+	ip, err := sp.AllocateHint(1)
+	if err != nil || !ip.Equal(net.ParseIP("10.64.0.0")) || len(ip) != net.IPv4len {
+		t.Fatalf("AllocateHint(1) = %v, %v; want 4-byte 10.64.0.0", ip, err)
+	}
+	if errRelease := sp.Release(ip); errRelease != nil {
+		t.Errorf("Release error: %v", errRelease)
+	}
+	if errRelease := sp.Release(net.ParseIP("2001:db8::")); errRelease == nil {
+		t.Error("expected error releasing IPv6 in an IPv4 pool, got nil")
+	}
+}
@@ -0,0 +1,227 @@
+package cidrx
+
+import (
+	"iter"
+	"maps"
+	"math/bits"
+	"net/netip"
+	"slices"
+)
+
+// The iterators below take the read lock one block at a time and copy that block's bitmap before yielding anything,
+// so the loop body may call back into the pool. Each block is seen in a consistent state, but changes made to the
+// pool while iterating may or may not show up in blocks that were not reached yet.
+
+// Allocated returns an iterator over every allocated address, in address order. Excluded addresses are skipped.
+func (p *Pool) Allocated() iter.Seq[netip.Addr] {
+	return func(yield func(netip.Addr) bool) {
+		var buf []uint64
+		for _, bi := range p.blockIndices() {
+			words, base, ok := p.copyBlock(bi, buf, true)
+			if !ok {
+				continue
+			}
+			buf = words
+
+			for wi, w := range words {
+				for w != 0 {
+					bit := uint64(bits.TrailingZeros64(w))
+					w &= w - 1
+					if !yield(p.outAddr(base.add(Uint128{Lo: uint64(wi)*64 + bit}).toAddr())) {
+						return
+					}
+				}
+			}
+		}
+	}
+}
+
+// FreeRanges returns an iterator over the addresses that can still be handed out, as the minimal list of prefixes
+// covering them, in address order. Blocks that were not created yet are entirely free, minus excluded addresses.
+func (p *Pool) FreeRanges() iter.Seq[netip.Prefix] {
+	return func(yield func(netip.Prefix) bool) {
+		// Contiguous free runs are merged across block boundaries before being split into prefixes
+		m := runMerger{fn: func(first, last Uint128) bool {
+			return p.yieldPrefixes(addrRange{first: first, last: last}, yield)
+		}}
+
+		next := p.networkAddr
+		var buf []uint64
+		for _, bi := range p.blockIndices() {
+			words, base, ok := p.copyBlock(bi, buf, false)
+			if !ok {
+				continue
+			}
+			buf = words
+
+			next = p.freeInBlock(next, base, words, m.add)
+			if m.stopped {
+				return
+			}
+		}
+		p.freeAfter(next, m.add)
+		m.flush()
+	}
+}
+
+// freeInBlock calls emit with the free runs between next, the end of the previous created block, and the end of the
+// block at base whose bitmap is words. Returns the address right after the block.
+func (p *Pool) freeInBlock(next, base Uint128, words []uint64, emit func(first, last Uint128)) Uint128 {
+	if base.cmp(next) > 0 {
+		p.freeUncreated(next, base.sub(Uint128{Lo: 1}), emit)
+	}
+	size := p.blockSize
+	for from := nextBit(words, 0, size, false); from < size; {
+		to := nextBit(words, from, size, true)
+		emit(base.add(Uint128{Lo: from}), base.add(Uint128{Lo: to - 1}))
+		from = nextBit(words, to, size, false)
+	}
+	return base.add(Uint128{Lo: size})
+}
+
+// freeAfter calls emit with the free runs from next, the end of the last created block, to the end of the network
+func (p *Pool) freeAfter(next Uint128, emit func(first, last Uint128)) {
+	// next wraps around to zero when the last block ends the address space
+	if last := p.lastAddr(); next.cmp(last) <= 0 && next.cmp(p.networkAddr) >= 0 {
+		p.freeUncreated(next, last, emit)
+	}
+}
+
+// runMerger merges free runs reported in address order, passing each maximal run to fn until fn returns false
+type runMerger struct {
+	fn      func(first, last Uint128) bool
+	run     addrRange
+	pending bool
+	stopped bool
+}
+
+// add reports the free run first-last
+func (m *runMerger) add(first, last Uint128) {
+	if m.stopped {
+		return
+	}
+	if m.pending && m.run.last.add(Uint128{Lo: 1}) == first {
+		m.run.last = last
+		return
+	}
+	if m.pending && !m.fn(m.run.first, m.run.last) {
+		m.stopped = true
+		return
+	}
+	m.run, m.pending = addrRange{first: first, last: last}, true
+}
+
+// flush passes the run still pending to fn
+func (m *runMerger) flush() {
+	if m.pending && !m.stopped {
+		m.stopped = !m.fn(m.run.first, m.run.last)
+	}
+	m.pending = false
+}
+
+// Blocks returns an iterator over the usage of every created block, in index order.
+func (p *Pool) Blocks() iter.Seq[BlockStats] {
+	return func(yield func(BlockStats) bool) {
+		for _, bi := range p.blockIndices() {
+			p.mu.RLock()
+			blk, ok := p.blocks[bi]
+			var st BlockStats
+			if ok {
+				st = p.blockStats(bi, blk)
+			}
+			p.mu.RUnlock()
+
+			if ok && !yield(st) {
+				return
+			}
+		}
+	}
+}
+
+// blockIndices returns the indices of the created blocks in order
+func (p *Pool) blockIndices() []uint64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return slices.Sorted(maps.Keys(p.blocks))
+}
+
+// copyBlock copies the bitmap of block bi into buf, growing it if needed, and returns it with the block base address.
+// When allocatedOnly is set the bits of excluded addresses are cleared from the copy. Returns false if the block does
+// not exist.
+func (p *Pool) copyBlock(bi uint64, buf []uint64, allocatedOnly bool) ([]uint64, Uint128, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	blk, ok := p.blocks[bi]
+	if !ok {
+		return buf, Uint128{}, false
+	}
+	buf = append(buf[:0], blk.used...)
+	if allocatedOnly {
+		p.excludedSpans(blk.base, blk.size, func(from, to uint64) {
+			for wi := from / 64; wi <= to/64; wi++ {
+				buf[wi] &^= spanMask(wi, from, to)
+			}
+		})
+	}
+	return buf, blk.base, true
+}
+
+// freeUncreated calls emit with the parts of first-last, which holds no created block, that are not excluded
+func (p *Pool) freeUncreated(first, last Uint128, emit func(first, last Uint128)) {
+	i, _ := slices.BinarySearchFunc(p.excluded, first, func(r addrRange, u Uint128) int { return r.last.cmp(u) })
+	for ; i < len(p.excluded) && p.excluded[i].first.cmp(last) <= 0; i++ {
+		r := p.excluded[i]
+		if r.first.cmp(first) > 0 {
+			emit(first, r.first.sub(Uint128{Lo: 1}))
+		}
+		if r.last.cmp(last) >= 0 {
+			return
+		}
+		first = r.last.add(Uint128{Lo: 1})
+	}
+	emit(first, last)
+}
+
+// yieldPrefixes splits r into the minimal list of prefixes covering it and yields them, returning false if yield
+// asked to stop
+func (p *Pool) yieldPrefixes(r addrRange, yield func(netip.Prefix) bool) bool {
+	first := r.first
+	for {
+		// Largest aligned prefix starting at first that does not go past the end of the range
+		k := min(first.trailingZeros(), ipv6BitLen)
+		for k > 0 && first.add(hostMask(uint(k))).cmp(r.last) > 0 {
+			k--
+		}
+
+		addr := p.outAddr(first.toAddr())
+		if !yield(netip.PrefixFrom(addr, addr.BitLen()-k)) {
+			return false
+		}
+
+		end := first.add(hostMask(uint(k)))
+		if end == r.last {
+			return true
+		}
+		first = end.add(Uint128{Lo: 1})
+	}
+}
+
+// nextBit returns the index of the first bit at or after from, and before size, that is set (or clear when set is
+// false). Returns size if there is none.
+func nextBit(words []uint64, from, size uint64, set bool) uint64 {
+	for wi := from / 64; wi < uint64(len(words)); wi++ {
+		w := words[wi]
+		if !set {
+			w = ^w
+		}
+		if wi == from/64 {
+			w &= ^uint64(0) << (from % 64)
+		}
+		if w != 0 {
+			return min(wi*64+uint64(bits.TrailingZeros64(w)), size)
+		}
+	}
+	return size
+}
@@ -0,0 +1,139 @@
+package cidrx //nolint:testpackage // it's OK to be just cidrx
+
+import (
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+// TestAllocatedIterator ensures Allocated yields every allocated address in order and skips excluded ones
+func TestAllocatedIterator(t *testing.T) {
+	pool, err := NewPoolFromPrefix(netip.MustParsePrefix("2001:db8::/64"), 120, 4,
+		WithExcludedPrefixes(netip.MustParsePrefix("2001:db8::/127")))
+	if err != nil {
+		t.Fatalf("NewPoolFromPrefix error: %v", err)
+	}
+
+	var want []netip.Addr
+	for i := 0; i < 70; i++ {
+		addr, errAllocate := pool.AllocateAddr()
+		if errAllocate != nil {
+			t.Fatalf("AllocateAddr error: %v", errAllocate)
+		}
+		want = append(want, addr)
+	}
+	if want[0] != netip.MustParseAddr("2001:db8::2") {
+		t.Fatalf("first allocation = %s; want the first address after the exclusion", want[0])
+	}
+	if errReserve := pool.ReserveAddr(netip.MustParseAddr("2001:db8::1:5")); errReserve != nil {
+		t.Fatalf("ReserveAddr error: %v", errReserve)
+	}
+	want = append(want, netip.MustParseAddr("2001:db8::1:5"))
+
+	got := slices.Collect(pool.Allocated())
+	if !slices.Equal(got, want) {
+		t.Errorf("Allocated() = %v; want %v", got, want)
+	}
+
+	// Stopping early must be honored
+	var n int
+	for range pool.Allocated() {
+		if n++; n == 3 {
+			break
+		}
+	}
+	if n != 3 {
+		t.Errorf("iterated %d addresses after break; want 3", n)
+	}
+}
+
+// TestFreeRanges ensures free space is reported as minimal prefixes, merged across blocks and minus exclusions
+func TestFreeRanges(t *testing.T) {
+	// /24 with /28 blocks => 16 blocks of 16 addresses
+	pool, err := NewPool("10.0.0.0", 24, 28, 4,
+		WithExcludedPrefixes(netip.MustParsePrefix("10.0.0.255/32")))
+	if err != nil {
+		t.Fatalf("NewPool error: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, errAllocate := pool.Allocate(); errAllocate != nil {
+			t.Fatalf("Allocate error: %v", errAllocate)
+		}
+	}
+	if errReserve := pool.ReserveAddr(netip.MustParseAddr("10.0.0.128")); errReserve != nil {
+		t.Fatalf("ReserveAddr error: %v", errReserve)
+	}
+
+	want := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.3/32"),
+		netip.MustParsePrefix("10.0.0.4/30"),
+		netip.MustParsePrefix("10.0.0.8/29"),
+		netip.MustParsePrefix("10.0.0.16/28"),
+		netip.MustParsePrefix("10.0.0.32/27"),
+		netip.MustParsePrefix("10.0.0.64/26"),
+		netip.MustParsePrefix("10.0.0.129/32"),
+		netip.MustParsePrefix("10.0.0.130/31"),
+		netip.MustParsePrefix("10.0.0.132/30"),
+		netip.MustParsePrefix("10.0.0.136/29"),
+		netip.MustParsePrefix("10.0.0.144/28"),
+		netip.MustParsePrefix("10.0.0.160/27"),
+		netip.MustParsePrefix("10.0.0.192/27"),
+		netip.MustParsePrefix("10.0.0.224/28"),
+		netip.MustParsePrefix("10.0.0.240/29"),
+		netip.MustParsePrefix("10.0.0.248/30"),
+		netip.MustParsePrefix("10.0.0.252/31"),
+		netip.MustParsePrefix("10.0.0.254/32"),
+	}
+	if got := slices.Collect(pool.FreeRanges()); !slices.Equal(got, want) {
+		t.Errorf("FreeRanges() = %v; want %v", got, want)
+	}
+}
+
+// TestFreeRangesEmpty ensures an untouched pool is reported as its own network, and a full one as nothing
+func TestFreeRangesEmpty(t *testing.T) {
+	prefix := netip.MustParsePrefix("2001:db8::/80")
+	pool, err := NewPoolFromPrefix(prefix, 112, 1)
+	if err != nil {
+		t.Fatalf("NewPoolFromPrefix error: %v", err)
+	}
+	if got := slices.Collect(pool.FreeRanges()); !slices.Equal(got, []netip.Prefix{prefix}) {
+		t.Errorf("FreeRanges() = %v; want [%s]", got, prefix)
+	}
+
+	full, err := NewPool("192.0.2.0", 30, 31, 2)
+	if err != nil {
+		t.Fatalf("NewPool error: %v", err)
+	}
+	for i := 0; i < 4; i++ {
+		if _, errAllocate := full.Allocate(); errAllocate != nil {
+			t.Fatalf("Allocate error: %v", errAllocate)
+		}
+	}
+	if got := slices.Collect(full.FreeRanges()); len(got) != 0 {
+		t.Errorf("FreeRanges() on full pool = %v; want none", got)
+	}
+}
+
+// TestBlocksIterator ensures Blocks yields the created blocks in index order
+func TestBlocksIterator(t *testing.T) {
+	pool, err := NewPool("2001:db8::", 64, 120, 4)
+	if err != nil {
+		t.Fatalf("NewPool error: %v", err)
+	}
+	for _, s := range []string{"2001:db8::5:1", "2001:db8::1", "2001:db8::2:0"} {
+		if errReserve := pool.ReserveAddr(netip.MustParseAddr(s)); errReserve != nil {
+			t.Fatalf("ReserveAddr(%s) error: %v", s, errReserve)
+		}
+	}
+
+	var got []uint64
+	for st := range pool.Blocks() {
+		if st.Allocated != 1 {
+			t.Errorf("block %d Allocated = %d; want 1", st.Index, st.Allocated)
+		}
+		got = append(got, st.Index)
+	}
+	if want := []uint64{0, 0x200, 0x500}; !slices.Equal(got, want) {
+		t.Errorf("Blocks() indices = %v; want %v", got, want)
+	}
+}
@@ -0,0 +1,551 @@
+package cidrx
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A journal directory holds at most a few files, all named after the generation they belong to:
+//
+//	snapshot-<gen>.bin  pool state at the moment generation <gen> started (Snapshot binary encoding)
+//	journal-<gen>.log   mutations applied after that moment
+//
+// Each log starts with a small header followed by records, whose size depends on their operation:
+//
+//	op       byte      journalAllocate, journalRelease, journalLease or journalRenew
+//	ip       [16]byte  the affected address
+//	id       uint64    lease ID, only for journalLease and journalRenew
+//	expires  int64     lease expiry in Unix nanoseconds, only for journalLease and journalRenew
+//	checksum uint32    CRC-32 (IEEE) of the fields above
+//
+// Compaction starts a new generation while the pool is locked, then writes the snapshot for it in the background and
+// removes every older file once the snapshot is durable. Recovery loads the newest snapshot and replays every log of
+// the same or a later generation in order.
+const (
+	journalMagic     = "CDRJ"
+	journalVersion   = 1
+	journalHeaderLen = len(journalMagic) + 2
+	journalRecordLen = 1 + net.IPv6len + 4
+	// records of lease operations also carry the lease ID and expiry
+	journalLeaseRecordLen = journalRecordLen + 8 + 8
+
+	journalSnapshotPrefix = "snapshot-"
+	journalSnapshotSuffix = ".bin"
+	journalLogPrefix      = "journal-"
+	journalLogSuffix      = ".log"
+	journalTmpSuffix      = ".tmp"
+
+	journalDirPerm  = 0o750
+	journalFilePerm = 0o640
+)
+
+// Journal record operations
+const (
+	journalAllocate byte = iota + 1
+	journalRelease
+	// allocation held by a new lease
+	journalLease
+	// new expiry of an existing lease
+	journalRenew
+)
+
+// JournalOptions tunes the durability and compaction of a Journal.
+type JournalOptions struct {
+	// Sync flushes every record to stable storage before the mutation returns. Without it records survive a process
+	// crash, but may be lost if the whole machine goes down.
+	Sync bool
+	// CompactEvery starts a background compaction after this many records. Zero disables automatic compaction,
+	// leaving it to explicit Compact calls.
+	CompactEvery int
+	// PoolOptions are applied when the pool is restored from a snapshot instead of built by newPool, so they should
+	// match the options newPool uses.
+	PoolOptions []Option
+}
+
+// Journal is an optional append-only log of every Allocate and Release applied to a Pool. Together with periodic
+// snapshots it allows rebuilding the exact pool state after a crash, so addresses are never handed out twice.
+type Journal struct {
+	dir  string
+	opts JournalOptions
+	pool *Pool
+
+	// The fields below are guarded by pool.mu, records are always appended while the pool is locked
+	f          *os.File
+	gen        uint64
+	size       int64 // length of the current log up to its last complete record
+	pending    int   // records appended to the current generation
+	compacting bool  // a background compaction is writing its snapshot
+	err        error
+	// failed is set once a record could not be written or cut off again, every later mutation fails with it
+	failed error
+
+	// tracks background compactions
+	wg sync.WaitGroup
+	// serializes snapshot writes so an older generation never lands after a newer one
+	writeMu sync.Mutex
+	written uint64
+}
+
+// OpenJournal recovers the pool stored in dir and starts journaling its mutations. If dir holds no snapshot yet,
+// newPool is called to create the initial pool, and any existing log is replayed on top of it, so newPool must always
+// build the same configuration. Once recovered, the journal is compacted into a fresh snapshot in the background.
+func OpenJournal(dir string, opts JournalOptions, newPool func() (*Pool, error)) (*Journal, error) {
+	if err := os.MkdirAll(dir, journalDirPerm); err != nil {
+		return nil, fmt.Errorf("create journal dir: %w", err)
+	}
+
+	snapGen, hasSnap, logGens, err := scanJournalDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Load the base state: the newest snapshot or a fresh pool
+	var p *Pool
+	if hasSnap {
+		p, err = loadJournalSnapshot(filepath.Join(dir, journalFileName(journalSnapshotPrefix, snapGen,
+			journalSnapshotSuffix)), opts.PoolOptions)
+	} else {
+		p, err = newPool()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Replay every log written after the snapshot was taken
+	gen := snapGen
+	for i, g := range logGens {
+		if g < snapGen {
+			continue
+		}
+		path := filepath.Join(dir, journalFileName(journalLogPrefix, g, journalLogSuffix))
+		if errReplay := replayJournal(p, path, i == len(logGens)-1); errReplay != nil {
+			return nil, errReplay
+		}
+		gen = g
+	}
+
+	j := &Journal{dir: dir, opts: opts, pool: p, gen: gen}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if errCompact := j.compactInBackgroundLocked(); errCompact != nil {
+		return nil, errCompact
+	}
+	p.journal = j
+	return j, nil
+}
+
+// Pool returns the pool whose mutations are being journaled.
+func (j *Journal) Pool() *Pool {
+	return j.pool
+}
+
+// Compact writes a snapshot of the pool and removes the journal records it covers. It blocks until the snapshot is
+// durable on disk.
+func (j *Journal) Compact() error {
+	p := j.pool
+	p.mu.Lock()
+	if p.journal != j {
+		p.mu.Unlock()
+		return ErrJournalClosed
+	}
+	snap, gen, err := j.rotateLocked()
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return j.writeSnapshot(snap, gen)
+}
+
+// Close stops journaling, waits for any background compaction and closes the log. The pool stays usable, but its
+// mutations are no longer recorded. Returns the first error hit by a background compaction, if any.
+func (j *Journal) Close() error {
+	p := j.pool
+	p.mu.Lock()
+	if p.journal != j {
+		p.mu.Unlock()
+		return ErrJournalClosed
+	}
+	p.journal = nil
+	errClose := errors.Join(j.f.Sync(), j.f.Close())
+	p.mu.Unlock()
+
+	j.wg.Wait()
+	return errors.Join(j.failed, j.err, errClose)
+}
+
+// append writes one record, as built by journalRecord, to the current log. A record that fails to be written is cut
+// off the log so later records don't land after torn bytes. If that is not possible, or the log could not be synced,
+// the journal fails for good. Called with pool.mu held.
+func (j *Journal) append(rec []byte) error {
+	if j.failed != nil {
+		return j.failed
+	}
+
+	if _, err := j.f.Write(rec); err != nil {
+		if errTruncate := j.f.Truncate(j.size); errTruncate != nil {
+			j.failed = fmt.Errorf("%w: append journal record: %w", ErrJournalFailed, errors.Join(err, errTruncate))
+			return j.failed
+		}
+		return fmt.Errorf("append journal record: %w", err)
+	}
+	if j.opts.Sync {
+		if err := j.f.Sync(); err != nil {
+			// What reached the disk is unknown after a failed sync, so the record can't be trusted either way
+			_ = j.f.Truncate(j.size)
+			j.failed = fmt.Errorf("%w: sync journal: %w", ErrJournalFailed, err)
+			return j.failed
+		}
+	}
+
+	j.size += int64(len(rec))
+	j.pending++
+	if j.opts.CompactEvery > 0 && j.pending >= j.opts.CompactEvery && !j.compacting {
+		// The record itself is durable, a failed rotation is retried on the next record and reported on Close
+		if err := j.compactInBackgroundLocked(); err != nil && j.err == nil {
+			j.err = err
+		}
+	}
+	return nil
+}
+
+// compactInBackgroundLocked starts a new generation and writes its snapshot from a separate goroutine. Called with
+// pool.mu held.
+func (j *Journal) compactInBackgroundLocked() error {
+	snap, gen, err := j.rotateLocked()
+	if err != nil {
+		return err
+	}
+
+	j.compacting = true
+	j.wg.Add(1)
+	go func() {
+		defer j.wg.Done()
+		errWrite := j.writeSnapshot(snap, gen)
+
+		j.pool.mu.Lock()
+		defer j.pool.mu.Unlock()
+		j.compacting = false
+		if errWrite != nil && j.err == nil {
+			j.err = errWrite
+		}
+	}()
+	return nil
+}
+
+// rotateLocked switches to a new log generation and returns a snapshot of the pool at the switch point. Called with
+// pool.mu held.
+func (j *Journal) rotateLocked() (*Snapshot, uint64, error) {
+	gen := j.gen + 1
+	f, err := createJournalLog(filepath.Join(j.dir, journalFileName(journalLogPrefix, gen, journalLogSuffix)))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if j.f != nil {
+		if errClose := errors.Join(j.f.Sync(), j.f.Close()); errClose != nil {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+			return nil, 0, fmt.Errorf("close journal log: %w", errClose)
+		}
+	}
+
+	j.f = f
+	j.gen = gen
+	j.size = int64(journalHeaderLen)
+	j.pending = 0
+	return j.pool.snapshotLocked(), gen, nil
+}
+
+// writeSnapshot durably stores the snapshot of generation gen and removes every file of older generations
+func (j *Journal) writeSnapshot(snap *Snapshot, gen uint64) error {
+	j.writeMu.Lock()
+	defer j.writeMu.Unlock()
+	if gen <= j.written {
+		// A newer snapshot already covers this generation
+		return nil
+	}
+
+	data, err := snap.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(j.dir, journalFileName(journalSnapshotPrefix, gen, journalSnapshotSuffix))
+	if errWrite := writeFileSync(path+journalTmpSuffix, data); errWrite != nil {
+		return errWrite
+	}
+	if errRename := os.Rename(path+journalTmpSuffix, path); errRename != nil {
+		return fmt.Errorf("install journal snapshot: %w", errRename)
+	}
+	if errSync := syncDir(j.dir); errSync != nil {
+		return errSync
+	}
+	j.written = gen
+
+	// The new snapshot covers everything before gen, older files are no longer needed
+	entries, err := os.ReadDir(j.dir)
+	if err != nil {
+		return fmt.Errorf("read journal dir: %w", err)
+	}
+	for _, e := range entries {
+		if g, ok := parseJournalFileName(e.Name()); ok && g < gen {
+			if errRemove := os.Remove(filepath.Join(j.dir, e.Name())); errRemove != nil && !os.IsNotExist(errRemove) {
+				return fmt.Errorf("remove stale journal file: %w", errRemove)
+			}
+		}
+	}
+	return nil
+}
+
+// scanJournalDir returns the newest snapshot generation, whether there is one, and the sorted log generations. Left
+// over temporary files from interrupted compactions are removed.
+func scanJournalDir(dir string) (uint64, bool, []uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, false, nil, fmt.Errorf("read journal dir: %w", err)
+	}
+
+	var snapGen uint64
+	var hasSnap bool
+	var logGens []uint64
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasSuffix(name, journalTmpSuffix) {
+			_ = os.Remove(filepath.Join(dir, name))
+			continue
+		}
+
+		g, ok := parseJournalFileName(name)
+		switch {
+		case !ok:
+		case strings.HasPrefix(name, journalSnapshotPrefix):
+			if !hasSnap || g > snapGen {
+				snapGen, hasSnap = g, true
+			}
+		default:
+			logGens = append(logGens, g)
+		}
+	}
+
+	slices.Sort(logGens)
+	return snapGen, hasSnap, logGens, nil
+}
+
+// loadJournalSnapshot decodes a snapshot file into a pool
+func loadJournalSnapshot(path string, opts []Option) (*Pool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read journal snapshot: %w", err)
+	}
+
+	var snap Snapshot
+	if errDecode := snap.UnmarshalBinary(data); errDecode != nil {
+		return nil, fmt.Errorf("decode %s: %w", filepath.Base(path), errDecode)
+	}
+	return NewPoolFromSnapshot(&snap, opts...)
+}
+
+// replayJournal applies every record of a log to p. Only the last log may end in a torn record, which is the one
+// being written when the process died, and it is ignored.
+func replayJournal(p *Pool, path string, last bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("read journal log: %w", err)
+	}
+	name := filepath.Base(path)
+
+	if len(data) < journalHeaderLen {
+		if !last {
+			return fmt.Errorf("%w: %s: short header", ErrJournalCorrupt, name)
+		}
+		// Died while creating the log, nothing was recorded in it
+		if errRemove := os.Remove(path); errRemove != nil {
+			return fmt.Errorf("remove torn journal log: %w", errRemove)
+		}
+		return nil
+	}
+	if string(data[:len(journalMagic)]) != journalMagic {
+		return fmt.Errorf("%w: %s: bad magic", ErrJournalCorrupt, name)
+	}
+	if v := binary.BigEndian.Uint16(data[len(journalMagic):]); v != journalVersion {
+		return fmt.Errorf("%w: %s: unsupported version %d", ErrJournalCorrupt, name, v)
+	}
+
+	records := data[journalHeaderLen:]
+	for n := 0; len(records) > 0; n++ {
+		size := journalRecordSize(records[0])
+		torn := len(records) < size
+		if !torn {
+			sum := binary.BigEndian.Uint32(records[size-4:])
+			torn = crc32.ChecksumIEEE(records[:size-4]) != sum
+		}
+		if torn {
+			if last && len(records) <= size {
+				// Cut the torn record off, otherwise it would no longer be at the tail once a newer log exists
+				if errTruncate := os.Truncate(path, int64(len(data)-len(records))); errTruncate != nil {
+					return fmt.Errorf("truncate torn journal log: %w", errTruncate)
+				}
+				return nil
+			}
+			return fmt.Errorf("%w: %s: record %d damaged", ErrJournalCorrupt, name, n)
+		}
+
+		if errApply := applyJournalRecord(p, records[:size-4]); errApply != nil {
+			return fmt.Errorf("%w: %s: record %d: %w", ErrJournalCorrupt, name, n, errApply)
+		}
+		records = records[size:]
+	}
+	return nil
+}
+
+// applyJournalRecord replays one recorded mutation, without its checksum, on p. The pool is not shared yet, so no
+// locking is needed.
+func applyJournalRecord(p *Pool, rec []byte) error {
+	op := rec[0]
+	u := Uint128{Hi: binary.BigEndian.Uint64(rec[1:9]), Lo: binary.BigEndian.Uint64(rec[9 : 1+net.IPv6len])}
+	var l Lease
+	if op == journalLease || op == journalRenew {
+		l = Lease{
+			ID:      LeaseID(binary.BigEndian.Uint64(rec[1+net.IPv6len:])),
+			Addr:    p.outAddr(u.toAddr()),
+			Expires: time.Unix(0, int64(binary.BigEndian.Uint64(rec[1+net.IPv6len+8:]))), //nolint:gosec // from int64
+		}
+	}
+
+	switch op {
+	case journalAllocate:
+		if _, _, err := p.claim(u); err != nil {
+			return p.addressError("allocate", u, err)
+		}
+		return nil
+	case journalRelease:
+		bi, idx, err := p.locate(u)
+		if err == nil {
+			err = p.free(bi, idx)
+		}
+		if err != nil {
+			return p.addressError("release", u, err)
+		}
+		p.dropLease(u)
+		return nil
+	case journalLease:
+		if _, dup := p.leases[l.ID]; dup || l.ID == 0 {
+			return fmt.Errorf("lease %d invalid or granted twice", l.ID)
+		}
+		if _, _, err := p.claim(u); err != nil {
+			return p.addressError("lease", u, err)
+		}
+		p.addLease(l)
+		p.nextLeaseID = max(p.nextLeaseID, l.ID)
+		return nil
+	case journalRenew:
+		held, ok := p.leases[l.ID]
+		if !ok || held.Addr != l.Addr {
+			return fmt.Errorf("%w: %d on %s", ErrLeaseNotFound, l.ID, l.Addr)
+		}
+		held.Expires = l.Expires
+		heap.Fix(&p.expiry, held.index)
+		return nil
+	default:
+		return fmt.Errorf("unknown operation %d", op)
+	}
+}
+
+// journalRecord builds the record of op on ip. Lease operations also carry the ID and expiry of l, which is ignored
+// otherwise.
+func journalRecord(op byte, ip Uint128, l Lease) []byte {
+	rec := make([]byte, 0, journalRecordSize(op))
+	rec = append(rec, op)
+	rec = binary.BigEndian.AppendUint64(rec, ip.Hi)
+	rec = binary.BigEndian.AppendUint64(rec, ip.Lo)
+	if op == journalLease || op == journalRenew {
+		rec = binary.BigEndian.AppendUint64(rec, uint64(l.ID))
+		rec = binary.BigEndian.AppendUint64(rec, uint64(l.Expires.UnixNano())) //nolint:gosec // decoded as int64
+	}
+	return binary.BigEndian.AppendUint32(rec, crc32.ChecksumIEEE(rec))
+}
+
+// journalRecordSize returns the length of a record of operation op, checksum included
+func journalRecordSize(op byte) int {
+	if op == journalLease || op == journalRenew {
+		return journalLeaseRecordLen
+	}
+	return journalRecordLen
+}
+
+// createJournalLog creates a log file and writes its header
+func createJournalLog(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY|os.O_APPEND, journalFilePerm)
+	if err != nil {
+		return nil, fmt.Errorf("create journal log: %w", err)
+	}
+
+	header := binary.BigEndian.AppendUint16([]byte(journalMagic), journalVersion)
+	if _, errWrite := f.Write(header); errWrite != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("write journal header: %w", errWrite)
+	}
+	return f, nil
+}
+
+// journalFileName formats the name of a journal file for generation gen
+func journalFileName(prefix string, gen uint64, suffix string) string {
+	return fmt.Sprintf("%s%016x%s", prefix, gen, suffix)
+}
+
+// parseJournalFileName extracts the generation from a snapshot or log file name
+func parseJournalFileName(name string) (uint64, bool) {
+	var rest string
+	switch {
+	case strings.HasPrefix(name, journalSnapshotPrefix) && strings.HasSuffix(name, journalSnapshotSuffix):
+		rest = strings.TrimSuffix(strings.TrimPrefix(name, journalSnapshotPrefix), journalSnapshotSuffix)
+	case strings.HasPrefix(name, journalLogPrefix) && strings.HasSuffix(name, journalLogSuffix):
+		rest = strings.TrimSuffix(strings.TrimPrefix(name, journalLogPrefix), journalLogSuffix)
+	default:
+		return 0, false
+	}
+
+	gen, err := strconv.ParseUint(rest, 16, 64)
+	return gen, err == nil
+}
+
+// writeFileSync writes data to path and flushes it to stable storage
+func writeFileSync(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, journalFilePerm)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", filepath.Base(path), err)
+	}
+	if _, errWrite := f.Write(data); errWrite != nil {
+		_ = f.Close()
+		return fmt.Errorf("write %s: %w", filepath.Base(path), errWrite)
+	}
+	if errSync := errors.Join(f.Sync(), f.Close()); errSync != nil {
+		return fmt.Errorf("sync %s: %w", filepath.Base(path), errSync)
+	}
+	return nil
+}
+
+// syncDir flushes directory entries, making renames and new files durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("open journal dir: %w", err)
+	}
+	if errSync := errors.Join(d.Sync(), d.Close()); errSync != nil {
+		return fmt.Errorf("sync journal dir: %w", errSync)
+	}
+	return nil
+}
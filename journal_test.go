@@ -0,0 +1,331 @@
+package cidrx //nolint:testpackage // it's OK to be just cidrx
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// crashJournal simulates a crash: background work is allowed to finish, but the journal is never closed cleanly
+func crashJournal(t *testing.T, j *Journal) {
+	t.Helper()
+	j.wg.Wait()
+	if err := j.f.Close(); err != nil {
+		t.Fatalf("close log: %v", err)
+	}
+}
+
+// TestJournalRecoverReplaysTail ensures allocations and releases made after the last snapshot survive a crash
+func TestJournalRecoverReplaysTail(t *testing.T) {
+	dir := t.TempDir()
+	newPool := func() (*Pool, error) { return NewPool("2001:db8::", 64, 124, 4) }
+	j, err := OpenJournal(dir, JournalOptions{}, newPool)
+	if err != nil {
+		t.Fatalf("OpenJournal error: %v", err)
+	}
+
+	// 40 IPs span three /124 blocks
+	pool := j.Pool()
+	ips := make([]net.IP, 40)
+	for i := range ips {
+		if ips[i], err = pool.Allocate(); err != nil {
+			t.Fatalf("Allocate error: %v", err)
+		}
+	}
+	for _, i := range []int{3, 17, 35} {
+		if errRelease := pool.Release(ips[i]); errRelease != nil {
+			t.Fatalf("Release error: %v", errRelease)
+		}
+	}
+	crashJournal(t, j)
+
+	recovered, err := OpenJournal(dir, JournalOptions{}, newPool)
+	if err != nil {
+		t.Fatalf("recover error: %v", err)
+	}
+	defer func() { _ = recovered.Close() }()
+
+	// Released IPs are free again, everything else is still held
+	for i, ip := range ips {
+		err = recovered.Pool().Release(ip)
+		released := i == 3 || i == 17 || i == 35
+		if released && err == nil {
+			t.Errorf("ips[%d] %v was released before the crash but is allocated after recovery", i, ip)
+		}
+		if !released && err != nil {
+			t.Errorf("ips[%d] %v lost after recovery: %v", i, ip, err)
+		}
+	}
+}
+
+// TestJournalNoDuplicatesAfterRecovery ensures a recovered pool never hands out an address held before the crash
+func TestJournalNoDuplicatesAfterRecovery(t *testing.T) {
+	dir := t.TempDir()
+	newPool := func() (*Pool, error) { return NewPool("2001:db8::", 64, 124, 4) }
+	j, _ := OpenJournal(dir, JournalOptions{CompactEvery: 7}, newPool)
+
+	held := make(map[string]struct{})
+	for i := 0; i < 50; i++ {
+		ip, err := j.Pool().Allocate()
+		if err != nil {
+			t.Fatalf("Allocate error: %v", err)
+		}
+		held[ip.String()] = struct{}{}
+	}
+	crashJournal(t, j)
+
+	recovered, err := OpenJournal(dir, JournalOptions{CompactEvery: 7}, newPool)
+	if err != nil {
+		t.Fatalf("recover error: %v", err)
+	}
+	defer func() { _ = recovered.Close() }()
+
+	for i := 0; i < 50; i++ {
+		ip, errAlloc := recovered.Pool().Allocate()
+		if errAlloc != nil {
+			t.Fatalf("Allocate after recovery error: %v", errAlloc)
+		}
+		if _, dup := held[ip.String()]; dup {
+			t.Fatalf("recovered pool handed out %v twice", ip)
+		}
+	}
+}
+
+// TestJournalTornTail ensures a partially written last record is ignored and cut off
+func TestJournalTornTail(t *testing.T) {
+	dir := t.TempDir()
+	newPool := func() (*Pool, error) { return NewPool("2001:db8::", 64, 124, 4) }
+	j, _ := OpenJournal(dir, JournalOptions{}, newPool)
+	ip, _ := j.Pool().Allocate()
+	logPath := j.f.Name()
+	crashJournal(t, j)
+
+	f, err := os.OpenFile(logPath, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("open log: %v", err)
+	}
+	_, _ = f.Write([]byte{journalAllocate, 0x20, 0x01})
+	_ = f.Close()
+
+	recovered, err := OpenJournal(dir, JournalOptions{}, newPool)
+	if err != nil {
+		t.Fatalf("recover with torn tail error: %v", err)
+	}
+	if errRelease := recovered.Pool().Release(ip); errRelease != nil {
+		t.Errorf("record before the torn tail lost: %v", errRelease)
+	}
+	crashJournal(t, recovered)
+
+	// The torn bytes are gone, so the log can be replayed again even though it is no longer the last one
+	again, err := OpenJournal(dir, JournalOptions{}, newPool)
+	if err != nil {
+		t.Fatalf("second recovery error: %v", err)
+	}
+	_ = again.Close()
+}
+
+// TestJournalCorruptRecord ensures damage in the middle of a log is reported instead of silently skipped
+func TestJournalCorruptRecord(t *testing.T) {
+	dir := t.TempDir()
+	newPool := func() (*Pool, error) { return NewPool("2001:db8::", 64, 124, 4) }
+	j, _ := OpenJournal(dir, JournalOptions{}, newPool)
+	for i := 0; i < 3; i++ {
+		_, _ = j.Pool().Allocate()
+	}
+	logPath := j.f.Name()
+	crashJournal(t, j)
+
+	data, _ := os.ReadFile(logPath)
+	data[journalHeaderLen+5] ^= 0xff
+	if err := os.WriteFile(logPath, data, journalFilePerm); err != nil {
+		t.Fatalf("write log: %v", err)
+	}
+
+	if _, err := OpenJournal(dir, JournalOptions{}, newPool); !errors.Is(err, ErrJournalCorrupt) {
+		t.Errorf("OpenJournal err = %v; want ErrJournalCorrupt", err)
+	}
+}
+
+// TestJournalCompact ensures compaction leaves a single generation on disk that restores the same state
+func TestJournalCompact(t *testing.T) {
+	dir := t.TempDir()
+	newPool := func() (*Pool, error) { return NewPool("2001:db8::", 64, 124, 4) }
+	j, _ := OpenJournal(dir, JournalOptions{}, newPool)
+	ips := make([]net.IP, 20)
+	for i := range ips {
+		ips[i], _ = j.Pool().Allocate()
+	}
+	if err := j.Compact(); err != nil {
+		t.Fatalf("Compact error: %v", err)
+	}
+	if err := j.Close(); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2 {
+		names := make([]string, 0, len(entries))
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Fatalf("dir holds %v; want one snapshot and one log", names)
+	}
+	// Entries are sorted by name, so the log comes first
+	info, err := os.Stat(filepath.Join(dir, entries[0].Name()))
+	if err != nil || info.Size() != int64(journalHeaderLen) {
+		t.Errorf("log after Compact: %v, %v; want only the header", info, err)
+	}
+
+	recovered, err := OpenJournal(dir, JournalOptions{}, newPool)
+	if err != nil {
+		t.Fatalf("recover error: %v", err)
+	}
+	defer func() { _ = recovered.Close() }()
+	for _, ip := range ips {
+		if errRelease := recovered.Pool().Release(ip); errRelease != nil {
+			t.Errorf("Release %v after compaction: %v", ip, errRelease)
+		}
+	}
+}
+
+// TestJournalClosed ensures a closed journal stops recording without breaking the pool
+func TestJournalClosed(t *testing.T) {
+	dir := t.TempDir()
+	newPool := func() (*Pool, error) { return NewPool("2001:db8::", 64, 124, 4) }
+	j, _ := OpenJournal(dir, JournalOptions{}, newPool)
+	if err := j.Close(); err != nil {
+		t.Fatalf("Close error: %v", err)
+	}
+	if err := j.Close(); !errors.Is(err, ErrJournalClosed) {
+		t.Errorf("second Close err = %v; want ErrJournalClosed", err)
+	}
+	if err := j.Compact(); !errors.Is(err, ErrJournalClosed) {
+		t.Errorf("Compact after Close err = %v; want ErrJournalClosed", err)
+	}
+	if _, err := j.Pool().Allocate(); err != nil {
+		t.Errorf("Allocate after Close error: %v", err)
+	}
+}
+
+// TestJournalReserve ensures reservations are journaled like allocations
+func TestJournalReserve(t *testing.T) {
+	dir := t.TempDir()
+	newPool := func() (*Pool, error) { return NewPool("2001:db8::", 64, 124, 4) }
+	j, _ := OpenJournal(dir, JournalOptions{}, newPool)
+	gateway := net.ParseIP("2001:db8::ffff")
+	if err := j.Pool().Reserve(gateway); err != nil {
+		t.Fatalf("Reserve error: %v", err)
+	}
+	crashJournal(t, j)
+
+	recovered, err := OpenJournal(dir, JournalOptions{}, newPool)
+	if err != nil {
+		t.Fatalf("recover error: %v", err)
+	}
+	defer func() { _ = recovered.Close() }()
+	if errReserve := recovered.Pool().Reserve(gateway); !errors.Is(errReserve, ErrAlreadyAllocated) {
+		t.Errorf("Reserve after recovery err = %v; want ErrAlreadyAllocated", errReserve)
+	}
+}
+
+// TestJournalWriteFailure ensures a record that can't be written or cut off fails every later mutation, and that the
+// log still replays to the state the callers saw
+func TestJournalWriteFailure(t *testing.T) {
+	dir := t.TempDir()
+	newPool := func() (*Pool, error) { return NewPool("2001:db8::", 64, 124, 4) }
+	j, _ := OpenJournal(dir, JournalOptions{}, newPool)
+	ip, _ := j.Pool().Allocate()
+
+	// A read-only handle makes both the write and the truncation fail
+	logFile := j.f
+	ro, err := os.Open(logFile.Name())
+	if err != nil {
+		t.Fatalf("open log: %v", err)
+	}
+	j.f = ro
+	if _, errAllocate := j.Pool().Allocate(); !errors.Is(errAllocate, ErrJournalFailed) {
+		t.Errorf("Allocate with a broken log err = %v; want ErrJournalFailed", errAllocate)
+	}
+	_ = ro.Close()
+
+	// The journal stays failed even once the log is writable again
+	j.f = logFile
+	if _, errAllocate := j.Pool().Allocate(); !errors.Is(errAllocate, ErrJournalFailed) {
+		t.Errorf("Allocate after the failure err = %v; want ErrJournalFailed", errAllocate)
+	}
+	if errRelease := j.Pool().Release(ip); !errors.Is(errRelease, ErrJournalFailed) {
+		t.Errorf("Release after the failure err = %v; want ErrJournalFailed", errRelease)
+	}
+	if n := j.Pool().allocated(); n != 1 {
+		t.Errorf("allocated() = %d; want 1", n)
+	}
+	crashJournal(t, j)
+
+	recovered, err := OpenJournal(dir, JournalOptions{}, newPool)
+	if err != nil {
+		t.Fatalf("recover error: %v", err)
+	}
+	defer func() { _ = recovered.Close() }()
+	if n := recovered.Pool().allocated(); n != 1 {
+		t.Errorf("allocated() after recovery = %d; want 1", n)
+	}
+}
+
+// TestJournalLeases ensures leases granted and renewed after the last snapshot survive a crash with their expiry, and
+// that lease IDs keep counting from where they were
+func TestJournalLeases(t *testing.T) {
+	dir := t.TempDir()
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	opts := JournalOptions{PoolOptions: []Option{WithClock(clock.now)}}
+	newPool := func() (*Pool, error) {
+		return NewPool("2001:db8::", 64, 124, 4, opts.PoolOptions...)
+	}
+
+	j, err := OpenJournal(dir, opts, newPool)
+	if err != nil {
+		t.Fatalf("OpenJournal error: %v", err)
+	}
+	renewed, first, _ := j.Pool().AllocateLease(time.Minute)
+	if errCompact := j.Compact(); errCompact != nil {
+		t.Fatalf("Compact error: %v", errCompact)
+	}
+	clock.advance(30 * time.Second)
+	if errRenew := j.Pool().Renew(first, time.Minute); errRenew != nil {
+		t.Fatalf("Renew error: %v", errRenew)
+	}
+	_, second, _ := j.Pool().AllocateLease(time.Minute)
+	crashJournal(t, j)
+
+	recovered, err := OpenJournal(dir, opts, newPool)
+	if err != nil {
+		t.Fatalf("recover error: %v", err)
+	}
+	defer func() { _ = recovered.Close() }()
+	pool := recovered.Pool()
+
+	// Past the expiry the first lease had before its renewal
+	clock.advance(45 * time.Second)
+	if n, errReap := pool.ReapExpired(); n != 0 || errReap != nil {
+		t.Errorf("ReapExpired = %d, %v; want the renewed lease kept", n, errReap)
+	}
+	if !pool.IsAllocated(renewed) {
+		t.Errorf("renewed lease address %v freed after recovery", renewed)
+	}
+	if errRenew := pool.Renew(second, time.Minute); errRenew != nil {
+		t.Errorf("Renew of the lease granted after the snapshot error: %v", errRenew)
+	}
+	if _, id, errLease := pool.AllocateLease(time.Minute); errLease != nil || id != second+1 {
+		t.Errorf("AllocateLease after recovery = %d, %v; want ID %d", id, errLease, second+1)
+	}
+	if errVerify := pool.Verify(); errVerify != nil {
+		t.Errorf("Verify error: %v", errVerify)
+	}
+
+	clock.advance(time.Hour)
+	if n, errReap := pool.ReapExpired(); n != 3 || errReap != nil {
+		t.Errorf("ReapExpired after every expiry = %d, %v; want 3", n, errReap)
+	}
+}
@@ -0,0 +1,176 @@
+package cidrx
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// LeaseID identifies a lease handed out by AllocateLease. IDs are never reused by a pool, zero is never a valid ID.
+type LeaseID uint64
+
+// Lease is an address held until Expires unless it is renewed or released first.
+type Lease struct {
+	ID      LeaseID
+	Addr    netip.Addr
+	Expires time.Time
+}
+
+// AllocateLease returns a free IP from the pool that is released automatically once ttl elapses without a Renew.
+// Expired leases are released by ReapExpired or RunReaper, and Release ends a lease early.
+//
+// Leases are part of the pool Snapshot, and a journal records them along with every renewal.
+func (p *Pool) AllocateLease(ttl time.Duration) (net.IP, LeaseID, error) {
+	if ttl <= 0 {
+		return nil, 0, fmt.Errorf("%w: lease TTL must be positive, got %s", ErrInvalidConfig, ttl)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	bi, idx, err := p.allocate()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// The lease record stands for the allocation too, so it is recovered as a whole or not at all
+	l := Lease{ID: p.nextLeaseID + 1, Addr: p.outAddr(p.blocks[bi].bitToAddr(idx)), Expires: p.now().Add(ttl)}
+	if errJournal := p.recordLease(journalLease, l); errJournal != nil {
+		_ = p.free(bi, idx)
+		return nil, 0, errJournal
+	}
+
+	p.nextLeaseID = l.ID
+	p.addLease(l)
+	return p.outIP(p.blocks[bi].bitToIP(idx)), l.ID, nil
+}
+
+// Renew extends a lease so it expires ttl from now. Returns ErrLeaseNotFound if the lease was released or reaped and
+// ErrLeaseExpired if it expired but was not reaped yet, in which case the address must not be used anymore.
+func (p *Pool) Renew(id LeaseID, ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("%w: lease TTL must be positive, got %s", ErrInvalidConfig, ttl)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	l, ok := p.leases[id]
+	if !ok {
+		return fmt.Errorf("%w: %d", ErrLeaseNotFound, id)
+	}
+	now := p.now()
+	if !now.Before(l.Expires) {
+		return fmt.Errorf("%w: %d", ErrLeaseExpired, id)
+	}
+
+	renewed := l.Lease
+	renewed.Expires = now.Add(ttl)
+	if err := p.recordLease(journalRenew, renewed); err != nil {
+		return err
+	}
+
+	l.Expires = renewed.Expires
+	heap.Fix(&p.expiry, l.index)
+	return nil
+}
+
+// ReapExpired releases every lease that has expired, through the same path as Release, and returns how many were
+// released.
+func (p *Pool) ReapExpired() (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	reaped := 0
+	for len(p.expiry) > 0 && !now.Before(p.expiry[0].Expires) {
+		// Releasing the address ends the lease, which takes it off the heap. On failure the lease is kept so the next
+		// run retries it.
+		u := fromAddr(p.expiry[0].Addr)
+		bi, _ := p.blockOf(u)
+		if err := p.release(bi, u.sub(p.blocks[bi].base).Lo, u); err != nil {
+			return reaped, p.addressError("reap", u, err)
+		}
+		reaped++
+	}
+	return reaped, nil
+}
+
+// RunReaper calls ReapExpired every interval until ctx is done. It is meant to run in its own goroutine and returns
+// the first error ReapExpired reports, or ctx.Err() once ctx is done. The interval must be positive.
+func (p *Pool) RunReaper(ctx context.Context, interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("%w: reaper interval must be positive, got %s", ErrInvalidConfig, interval)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			if _, err := p.ReapExpired(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// addLease registers l, the caller must hold p.mu
+func (p *Pool) addLease(l Lease) {
+	if p.leases == nil {
+		p.leases = make(map[LeaseID]*leaseEntry)
+		p.leaseOf = make(map[Uint128]LeaseID)
+	}
+	e := &leaseEntry{Lease: l}
+	p.leases[l.ID] = e
+	p.leaseOf[fromAddr(l.Addr)] = l.ID
+	heap.Push(&p.expiry, e)
+}
+
+// dropLease ends the lease on u, if there is one, and takes it off the reaper heap
+func (p *Pool) dropLease(u Uint128) {
+	if id, ok := p.leaseOf[u]; ok {
+		heap.Remove(&p.expiry, p.leases[id].index)
+		delete(p.leaseOf, u)
+		delete(p.leases, id)
+	}
+}
+
+// leaseEntry is a lease held by a pool, along with its position in the reaper heap
+type leaseEntry struct {
+	Lease
+	index int
+}
+
+// leaseHeap is a min-heap of leases ordered by expiry, implementing heap.Interface. Every lease keeps its index up to
+// date, so a renewed or released lease is moved or removed in place.
+type leaseHeap []*leaseEntry
+
+func (h leaseHeap) Len() int           { return len(h) }
+func (h leaseHeap) Less(i, j int) bool { return h[i].Expires.Before(h[j].Expires) }
+
+func (h leaseHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *leaseHeap) Push(x any) {
+	e := x.(*leaseEntry) //nolint:errcheck // only *leaseEntry is ever pushed
+	e.index = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *leaseHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return e
+}
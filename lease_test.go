@@ -0,0 +1,233 @@
+package cidrx //nolint:testpackage // it's OK to be just cidrx
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeClock is a manually advanced clock for WithClock
+type fakeClock struct {
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.t = c.t.Add(d)
+}
+
+// TestLeaseExpiry ensures only expired leases are reaped and their addresses become free again
+func TestLeaseExpiry(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	pool, _ := NewPool("2001:db8::", 64, 124, 4, WithClock(clock.now))
+
+	short, _, err := pool.AllocateLease(time.Minute)
+	if err != nil {
+		t.Fatalf("AllocateLease error: %v", err)
+	}
+	long, _, err := pool.AllocateLease(time.Hour)
+	if err != nil {
+		t.Fatalf("AllocateLease error: %v", err)
+	}
+
+	clock.advance(59 * time.Second)
+	if n, errReap := pool.ReapExpired(); n != 0 || errReap != nil {
+		t.Fatalf("ReapExpired before expiry = %d, %v; want 0", n, errReap)
+	}
+
+	clock.advance(time.Second)
+	if n, errReap := pool.ReapExpired(); n != 1 || errReap != nil {
+		t.Fatalf("ReapExpired at expiry = %d, %v; want 1", n, errReap)
+	}
+	if errRelease := pool.Release(short); errRelease == nil {
+		t.Error("expired lease address still allocated after reaping")
+	}
+	if errRelease := pool.Release(long); errRelease != nil {
+		t.Errorf("unexpired lease address released early: %v", errRelease)
+	}
+}
+
+// TestLeaseRenew ensures a renewed lease outlives its original TTL, and expired or unknown leases can't be renewed
+func TestLeaseRenew(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	pool, _ := NewPool("2001:db8::", 64, 124, 4, WithClock(clock.now))
+
+	ip, id, err := pool.AllocateLease(time.Minute)
+	if err != nil {
+		t.Fatalf("AllocateLease error: %v", err)
+	}
+
+	clock.advance(30 * time.Second)
+	if errRenew := pool.Renew(id, time.Minute); errRenew != nil {
+		t.Fatalf("Renew error: %v", errRenew)
+	}
+
+	// Past the original expiry, but within the renewed one
+	clock.advance(45 * time.Second)
+	if n, _ := pool.ReapExpired(); n != 0 {
+		t.Fatalf("ReapExpired reaped %d renewed leases; want 0", n)
+	}
+
+	clock.advance(15 * time.Second)
+	if errRenew := pool.Renew(id, time.Minute); !errors.Is(errRenew, ErrLeaseExpired) {
+		t.Errorf("Renew of expired lease err = %v; want ErrLeaseExpired", errRenew)
+	}
+	if n, _ := pool.ReapExpired(); n != 1 {
+		t.Fatalf("ReapExpired = %d; want 1", n)
+	}
+	if errRenew := pool.Renew(id, time.Minute); !errors.Is(errRenew, ErrLeaseNotFound) {
+		t.Errorf("Renew of reaped lease err = %v; want ErrLeaseNotFound", errRenew)
+	}
+	if errRelease := pool.Release(ip); errRelease == nil {
+		t.Error("reaped lease address still allocated")
+	}
+	if errRenew := pool.Renew(id, 0); errRenew == nil {
+		t.Error("expected error renewing with a zero TTL, got nil")
+	}
+}
+
+// TestLeaseRelease ensures releasing a leased address ends the lease, so the reaper never frees the address again
+func TestLeaseRelease(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	pool, _ := NewPool("2001:db8::", 64, 124, 4, WithClock(clock.now))
+
+	ip, id, _ := pool.AllocateLease(time.Minute)
+	if err := pool.Release(ip); err != nil {
+		t.Fatalf("Release error: %v", err)
+	}
+	if err := pool.Renew(id, time.Minute); !errors.Is(err, ErrLeaseNotFound) {
+		t.Errorf("Renew after Release err = %v; want ErrLeaseNotFound", err)
+	}
+
+	// The address goes to a new holder without a lease, which the stale expiry must not touch
+	again, _ := pool.Allocate()
+	if !again.Equal(ip) {
+		t.Fatalf("Allocate = %v; want the released %v", again, ip)
+	}
+	clock.advance(time.Hour)
+	if n, _ := pool.ReapExpired(); n != 0 {
+		t.Errorf("ReapExpired = %d; want 0", n)
+	}
+	if err := pool.Release(again); err != nil {
+		t.Errorf("plain allocation reaped by a stale lease: %v", err)
+	}
+}
+
+// TestLeaseHeapEntries ensures renewing a lease moves its expiry instead of adding one, and releasing it removes it
+func TestLeaseHeapEntries(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	pool, _ := NewPool("2001:db8::", 64, 124, 4, WithClock(clock.now))
+
+	ip, id, _ := pool.AllocateLease(time.Minute)
+	_, other, _ := pool.AllocateLease(2 * time.Minute)
+	for i := 0; i < 100; i++ {
+		clock.advance(time.Second)
+		if err := pool.Renew(id, time.Minute); err != nil {
+			t.Fatalf("Renew error: %v", err)
+		}
+	}
+	if len(pool.expiry) != 2 {
+		t.Errorf("expiry heap holds %d entries after renewals; want 2", len(pool.expiry))
+	}
+	if pool.expiry[0].ID != other {
+		t.Errorf("lease %d expires first; want %d", pool.expiry[0].ID, other)
+	}
+
+	if err := pool.Release(ip); err != nil {
+		t.Fatalf("Release error: %v", err)
+	}
+	if len(pool.expiry) != 1 {
+		t.Errorf("expiry heap holds %d entries after Release; want 1", len(pool.expiry))
+	}
+	if err := pool.Verify(); err != nil {
+		t.Errorf("Verify error: %v", err)
+	}
+}
+
+// TestLeaseSnapshot ensures leases survive a snapshot round trip, including the binary encoding
+func TestLeaseSnapshot(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	pool, _ := NewPool("2001:db8::", 64, 124, 4, WithClock(clock.now))
+
+	ips := make([]net.IP, 3)
+	ids := make([]LeaseID, 3)
+	for i, ttl := range []time.Duration{time.Minute, time.Hour, 2 * time.Hour} {
+		var err error
+		if ips[i], ids[i], err = pool.AllocateLease(ttl); err != nil {
+			t.Fatalf("AllocateLease error: %v", err)
+		}
+	}
+
+	data, err := pool.Snapshot().MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary error: %v", err)
+	}
+	var snap Snapshot
+	if errDecode := snap.UnmarshalBinary(data); errDecode != nil {
+		t.Fatalf("UnmarshalBinary error: %v", errDecode)
+	}
+	restored, err := NewPoolFromSnapshot(&snap, WithClock(clock.now))
+	if err != nil {
+		t.Fatalf("NewPoolFromSnapshot error: %v", err)
+	}
+
+	clock.advance(90 * time.Minute)
+	if errRenew := restored.Renew(ids[2], time.Hour); errRenew != nil {
+		t.Errorf("Renew after restore error: %v", errRenew)
+	}
+	if n, _ := restored.ReapExpired(); n != 2 {
+		t.Errorf("ReapExpired after restore = %d; want 2", n)
+	}
+	if errRelease := restored.Release(ips[2]); errRelease != nil {
+		t.Errorf("renewed lease address not allocated: %v", errRelease)
+	}
+
+	// Lease IDs keep counting from where the snapshot left off
+	_, id, _ := restored.AllocateLease(time.Minute)
+	if id != ids[2]+1 {
+		t.Errorf("next lease ID = %d; want %d", id, ids[2]+1)
+	}
+}
+
+// TestRunReaper ensures the background reaper stops with its context
+func TestRunReaper(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	pool, _ := NewPool("2001:db8::", 64, 124, 4, WithClock(clock.now))
+	ip, _, _ := pool.AllocateLease(time.Minute)
+	clock.advance(time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- pool.RunReaper(ctx, time.Millisecond) }()
+
+	deadline := time.After(5 * time.Second)
+	for {
+		pool.mu.Lock()
+		leased := len(pool.leases)
+		pool.mu.Unlock()
+		if leased == 0 {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("reaper did not release the expired lease")
+		case <-time.After(time.Millisecond):
+		}
+	}
+	if err := pool.Release(ip); err == nil {
+		t.Error("expired lease address still allocated")
+	}
+
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("RunReaper err = %v; want context.Canceled", err)
+	}
+	if err := pool.RunReaper(context.Background(), 0); !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("RunReaper with a zero interval err = %v; want ErrInvalidConfig", err)
+	}
+}
@@ -0,0 +1,92 @@
+package cidrx
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+)
+
+// NewPoolFromPrefix constructs a Pool for the given IPv6 or IPv4 network prefix, split into blocks of blockPrefix
+// length. It accepts the same blockPrefix and expectedBlocks as NewPool, and host bits set in prefix are ignored.
+// IPv4-mapped IPv6 prefixes are rejected, IPv4 networks must be given as plain IPv4 prefixes.
+func NewPoolFromPrefix(prefix netip.Prefix, blockPrefix, expectedBlocks int, opts ...Option) (*Pool, error) {
+	if !prefix.IsValid() || prefix.Addr().Is4In6() {
+		return nil, fmt.Errorf("%w: %s is not an IPv6 or IPv4 prefix", ErrInvalidPrefix, prefix)
+	}
+
+	return newPool(net.IP(prefix.Addr().AsSlice()), prefix.Bits(), blockPrefix, expectedBlocks, opts)
+}
+
+// Prefix returns the network covered by the pool.
+func (p *Pool) Prefix() netip.Prefix {
+	ones, _ := p.blockMask.Size()
+	if p.ipv4 {
+		return netip.PrefixFrom(p.networkAddr.toAddr().Unmap(), ones-ipv4MappedBits)
+	}
+	return netip.PrefixFrom(p.networkAddr.toAddr(), ones)
+}
+
+// AllocateAddr returns a free IP from the pool as a netip.Addr. Unlike Allocate it does not allocate on the heap.
+func (p *Pool) AllocateAddr() (netip.Addr, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	bi, idx, err := p.allocateRecorded()
+	if err != nil {
+		return netip.Addr{}, err
+	}
+	return p.outAddr(p.blocks[bi].bitToAddr(idx)), nil
+}
+
+// ReleaseAddr frees an address obtained from AllocateAddr (or Allocate) back to the pool.
+func (p *Pool) ReleaseAddr(addr netip.Addr) error {
+	u, ok := p.inAddr(addr)
+	if !ok {
+		return notInPool("release", addr.AsSlice())
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.releaseAt("release", u)
+}
+
+// Contains reports whether addr lies within the network covered by the pool, whether or not it is allocated.
+func (p *Pool) Contains(addr netip.Addr) bool {
+	u, ok := p.inAddr(addr)
+	if !ok {
+		return false
+	}
+
+	_, ok = p.blockOf(u)
+	return ok
+}
+
+// IsAllocatedAddr is the netip counterpart of IsAllocated.
+func (p *Pool) IsAllocatedAddr(addr netip.Addr) bool {
+	u, ok := p.inAddr(addr)
+	if !ok {
+		return false
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return p.isAllocated(u)
+}
+
+// ReserveAddr is the netip counterpart of Reserve.
+func (p *Pool) ReserveAddr(addr netip.Addr) error {
+	u, ok := p.inAddr(addr)
+	if !ok {
+		return notInPool("reserve", addr.AsSlice())
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.reserve(u); err != nil {
+		return p.addressError("reserve", u, err)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package cidrx //nolint:testpackage // it's OK to be just cidrx
+
+import (
+	"net/netip"
+	"testing"
+)
+
+// TestNewPoolFromPrefix ensures a prefix-built pool hands out the same sequence as its string-built twin
+func TestNewPoolFromPrefix(t *testing.T) {
+	fromPrefix, err := NewPoolFromPrefix(netip.MustParsePrefix("2001:db8:0:1::/64"), 120, 4)
+	if err != nil {
+		t.Fatalf("NewPoolFromPrefix error: %v", err)
+	}
+	fromString, _ := NewPool("2001:db8:0:1::", 64, 120, 4)
+
+	if got := fromPrefix.Prefix(); got != netip.MustParsePrefix("2001:db8:0:1::/64") {
+		t.Errorf("Prefix() = %s", got)
+	}
+	for i := 0; i < 300; i++ {
+		addr, errAddr := fromPrefix.AllocateAddr()
+		ip, errIP := fromString.Allocate()
+		if errAddr != nil || errIP != nil {
+			t.Fatalf("allocate #%d: %v / %v", i, errAddr, errIP)
+		}
+		if addr.String() != ip.String() {
+			t.Fatalf("allocate #%d: AllocateAddr = %s, Allocate = %s", i, addr, ip)
+		}
+	}
+}
+
+// TestNewPoolFromPrefixInvalid ensures invalid and IPv4-mapped prefixes, and out of range block prefixes, are rejected
+func TestNewPoolFromPrefixInvalid(t *testing.T) {
+	for _, prefix := range []netip.Prefix{
+		{},
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("::ffff:10.0.0.0/104"),
+	} {
+		if _, err := NewPoolFromPrefix(prefix, 120, 1); err == nil {
+			t.Errorf("NewPoolFromPrefix(%s): expected error, got nil", prefix)
+		}
+	}
+
+	// Block prefix rules are the same as NewPool
+	if _, err := NewPoolFromPrefix(netip.MustParsePrefix("2001:db8::/64"), 64, 1); err == nil {
+		t.Error("NewPoolFromPrefix with block prefix equal to network prefix: expected error, got nil")
+	}
+}
+
+// TestAllocateReleaseAddr ensures addresses move between the netip and net.IP APIs
+func TestAllocateReleaseAddr(t *testing.T) {
+	pool, _ := NewPoolFromPrefix(netip.MustParsePrefix("2001:db8::/64"), 126, 1)
+
+	addr, err := pool.AllocateAddr()
+	if err != nil {
+		t.Fatalf("AllocateAddr error: %v", err)
+	}
+	if errRelease := pool.ReleaseAddr(addr); errRelease != nil {
+		t.Fatalf("ReleaseAddr error: %v", errRelease)
+	}
+	if errRelease := pool.ReleaseAddr(addr); errRelease == nil {
+		t.Error("expected error on double ReleaseAddr, got nil")
+	}
+
+	// An address from Allocate can be released through ReleaseAddr
+	ip, _ := pool.Allocate()
+	if errRelease := pool.ReleaseAddr(netip.MustParseAddr(ip.String())); errRelease != nil {
+		t.Errorf("ReleaseAddr of Allocate'd IP error: %v", errRelease)
+	}
+
+	for _, s := range []string{"2001:db8:0:1::", "2001:db7::", "10.0.0.1"} {
+		if errRelease := pool.ReleaseAddr(netip.MustParseAddr(s)); errRelease == nil {
+			t.Errorf("ReleaseAddr(%s): expected error, got nil", s)
+		}
+	}
+}
+
+// TestContains ensures Contains matches exactly the pool network
+func TestContains(t *testing.T) {
+	pool, _ := NewPoolFromPrefix(netip.MustParsePrefix("2001:db8:0:8::/62"), 120, 1)
+
+	cases := map[string]bool{
+		"2001:db8:0:8::":                          true,
+		"2001:db8:0:b:ffff:ffff:ffff:ffff":        true,
+		"2001:db8:0:7:ffff:ffff:ffff:ffff":        false,
+		"2001:db8:0:c::":                          false,
+		"::":                                      false,
+		"ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff": false,
+		"10.0.0.1":                                false,
+	}
+	for s, want := range cases {
+		if got := pool.Contains(netip.MustParseAddr(s)); got != want {
+			t.Errorf("Contains(%s) = %v; want %v", s, got, want)
+		}
+	}
+	if pool.Contains(netip.Addr{}) {
+		t.Error("Contains(zero Addr) = true; want false")
+	}
+}
+
+// TestAllocateAddrNoHeap ensures the netip hot path does not allocate
+func TestAllocateAddrNoHeap(t *testing.T) {
+	pool, _ := NewPoolFromPrefix(netip.MustParsePrefix("2001:db8::/64"), 120, 1)
+	// Create the block up front, block creation is not part of the hot path
+	addr, _ := pool.AllocateAddr()
+	_ = pool.ReleaseAddr(addr)
+
+	allocs := testing.AllocsPerRun(1000, func() {
+		a, err := pool.AllocateAddr()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if errRelease := pool.ReleaseAddr(a); errRelease != nil {
+			t.Fatal(errRelease)
+		}
+	})
+	if allocs != 0 {
+		t.Errorf("AllocateAddr+ReleaseAddr = %v allocs/op; want 0", allocs)
+	}
+}
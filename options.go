@@ -0,0 +1,26 @@
+package cidrx
+
+import "time"
+
+// Option configures optional behavior of a Pool. Options are accepted by every Pool constructor.
+type Option func(*Pool)
+
+// WithClock sets the function the pool reads the current time from, which drives lease expiry. It defaults to
+// time.Now and is meant to be replaced in tests.
+func WithClock(now func() time.Time) Option {
+	return func(p *Pool) {
+		p.now = now
+	}
+}
+
+// applyOptions sets the defaults and then applies opts in order, returning the first error an option reported
+func (p *Pool) applyOptions(opts []Option) error {
+	p.now = time.Now
+	for _, opt := range opts {
+		opt(p)
+		if p.optErr != nil {
+			return p.optErr
+		}
+	}
+	return nil
+}
@@ -4,13 +4,14 @@ import (
 	"fmt"
 	"net"
 	"sync"
+	"time"
 )
 
 const (
 	ipv6BitLen = net.IPv6len * 8
 )
 
-// Pool manages IPv6 allocations using Uint128 arithmetic and a pre-reserved free list.
+// Pool manages IPv6 or IPv4 allocations using Uint128 arithmetic and a pre-reserved free list.
 type Pool struct {
 	// extracts a block’s network base by masking off host-offset bits
 	blockMask net.IPMask
@@ -30,29 +31,84 @@ type Pool struct {
 	// maxBlocks represent the maximum number of blocks that can be allocated. This creates a hard limit of 2^63 blocks
 	// being allowed
 	maxBlocks uint64
-	// protects freeList and blocks
-	mu sync.Mutex
+	// ipv4 is set for IPv4 networks, which are kept in the IPv4-mapped range internally (see ipv4.go)
+	ipv4 bool
+	// excluded address ranges, sorted, merged and clipped to the network. They are marked as used in every block
+	// when it is created and can't be reserved nor released.
+	excluded []addrRange
+	// journal records every mutation when the pool was opened through OpenJournal
+	journal *Journal
+
+	// leases held by AllocateLease, indexed by ID and by address, and ordered by expiry for the reaper
+	leases      map[LeaseID]*leaseEntry
+	leaseOf     map[Uint128]LeaseID
+	expiry      leaseHeap
+	nextLeaseID LeaseID
+	// picks the address Allocate hands out, nil for the default, see WithAllocationStrategy
+	strategy AllocationStrategy
+	// returns the current time, see WithClock
+	now func() time.Time
+	// first error reported by an option, returned by the constructor
+	optErr error
+
+	// block reclaim, see WithBlockReclaim: idle queues blocks in the order they emptied and idleSince holds the latest
+	// time each one did; reclaimed holds the dropped block indices below nextBlockIndex, highest first
+	reclaim      bool
+	reclaimGrace time.Duration
+	idle         []idleBlock
+	idleSince    map[uint64]time.Time
+	reclaimed    []uint64
+	// reclaimed blocks kept to back new ones, holding spareBytes of bitmaps out of spareMax, see WithBitmapRecycling
+	spare      []*block
+	spareBytes uint64
+	spareMax   uint64
+
+	// protects freeList, blocks and leases; queries only take the read lock
+	mu sync.RWMutex
 }
 
-// NewPool constructs a Pool that transforms the given IPv6 network into fixed-size blocks (bitmaps).
+// NewPool constructs a Pool that transforms the given IPv6 or IPv4 network into fixed-size blocks (bitmaps).
 //
-//	networkAddress   – the base IPv6 network (e.g. "2001:db8::")
-//	netPrefixLen     – the prefix length of the network (0 ≤ netPrefixLen ≤ 128)
+//	networkAddress   – the base IPv6 or IPv4 network (e.g. "2001:db8::" or "10.0.0.0")
+//	netPrefixLen     – the prefix length of the network (0 ≤ netPrefixLen ≤ 128, or 32 for IPv4)
 //	blockPrefix      – the prefix length of each allocation block; must satisfy
-//	                   netPrefixLen < blockPrefix ≤ 128 and (blockPrefix – netPrefixLen) ≤ 63
+//	                   netPrefixLen < blockPrefix ≤ 128 (32 for IPv4) and (blockPrefix – netPrefixLen) ≤ 63
 //	expectedBlocks   – an estimate of how many blocks you’ll use, to pre-reserve
 //	                   freeList capacity (avoids slice reallocations on Allocate)
+//	opts             – optional settings such as WithClock
 //
 // Returns a *Pool ready to Allocate() and Release() IPs, or an error if any arguments
 // are invalid (bad IP, out-of-range prefixes, or too many blocks)
-func NewPool(netAddress string, netPrefixLen, blockPrefix, expectedBlocks int) (*Pool, error) {
+func NewPool(netAddress string, netPrefixLen, blockPrefix, expectedBlocks int, opts ...Option) (*Pool, error) {
 	ip := net.ParseIP(netAddress)
-	if ip == nil || ip.To16() == nil || ip.To4() != nil {
-		return nil, fmt.Errorf("invalid IPv6 address %q", netAddress)
+	if ip == nil {
+		return nil, fmt.Errorf("%w: invalid IPv6 or IPv4 address %q", ErrInvalidPrefix, netAddress)
 	}
 
+	return newPool(ip, netPrefixLen, blockPrefix, expectedBlocks, opts)
+}
+
+// newPool validates the prefix lengths and builds the Pool for an already parsed network address. IPv4 networks are
+// moved into the IPv4-mapped range first.
+func newPool(ip net.IP, netPrefixLen, blockPrefix, expectedBlocks int, opts []Option) (*Pool, error) {
+	if ip.To4() == nil {
+		return initPool(ip, netPrefixLen, blockPrefix, expectedBlocks, false, opts)
+	}
+
+	if netPrefixLen < 0 || netPrefixLen > ipv4BitLen {
+		return nil, fmt.Errorf("%w: baseCIDR prefix must be between 0 and %d for IPv4", ErrInvalidPrefix, ipv4BitLen)
+	}
+	if blockPrefix <= netPrefixLen || blockPrefix > ipv4BitLen {
+		return nil, fmt.Errorf("%w: block prefix must be > /%d and ≤%d for IPv4", ErrInvalidPrefix, netPrefixLen,
+			ipv4BitLen)
+	}
+	return initPool(ip.To16(), netPrefixLen+ipv4MappedBits, blockPrefix+ipv4MappedBits, expectedBlocks, true, opts)
+}
+
+// initPool validates the prefix lengths, given in the 128-bit address space, and builds the Pool
+func initPool(ip net.IP, netPrefixLen, blockPrefix, expectedBlocks int, ipv4 bool, opts []Option) (*Pool, error) {
 	if netPrefixLen < 0 || netPrefixLen > (ipv6BitLen) {
-		return nil, fmt.Errorf("baseCIDR prefix must be between 0 and 128")
+		return nil, fmt.Errorf("%w: baseCIDR prefix must be between 0 and 128", ErrInvalidPrefix)
 	}
 
 	// Create the base CIDR
@@ -61,13 +117,13 @@ func NewPool(netAddress string, netPrefixLen, blockPrefix, expectedBlocks int) (
 
 	// Ensure block prefix length is larger than network prefix
 	if blockPrefix <= netPrefixLen || blockPrefix > ipv6BitLen {
-		return nil, fmt.Errorf("block prefix must be > /%d and ≤128", netPrefixLen)
+		return nil, fmt.Errorf("%w: block prefix must be > /%d and ≤128", ErrInvalidPrefix, netPrefixLen)
 	}
 
 	// Make sure number of blocks will be able to fitted in a uint64 (hard limit of 2^63 blocks)
 	diff := blockPrefix - netPrefixLen
 	if diff > 63 {
-		return nil, fmt.Errorf("too many blocks (diff %d >63)", diff)
+		return nil, fmt.Errorf("%w: too many blocks (diff %d >63)", ErrInvalidPrefix, diff)
 	}
 
 	maxBlocks := uint64(1) << uint(diff) // number of blocks available for this prefix
@@ -82,87 +138,342 @@ func NewPool(netAddress string, netPrefixLen, blockPrefix, expectedBlocks int) (
 		blocks:      make(map[uint64]*block),
 		freeList:    make([]uint64, 0, expectedBlocks),
 		maxBlocks:   maxBlocks,
+		ipv4:        ipv4,
 	}
+	if err := pool.applyOptions(opts); err != nil {
+		return nil, err
+	}
+	pool.applyExclusions()
 	return pool, nil
 }
 
-// Allocate returns a free IPv6 from the pool.
+// Allocate returns a free IP from the pool, in its 4-byte form for IPv4 pools.
 func (p *Pool) Allocate() (net.IP, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	// Check if we have any free blocks with ready-to-use IPs
-	for len(p.freeList) > 0 {
-		// Pop the last block index from the freeList
-		bi := p.freeList[len(p.freeList)-1]
-		p.freeList = p.freeList[:len(p.freeList)-1]
+	bi, idx, err := p.allocateRecorded()
+	if err != nil {
+		return nil, err
+	}
+	return p.outIP(p.blocks[bi].bitToIP(idx)), nil
+}
 
-		// Try to allocate an IP (as a bit) from the block
-		blk := p.blocks[bi]
-		idx, err := blk.allocBit()
-		if err == nil {
-			// If allocation was successful, check if the block still has free space and push it back to the freeList
-			if blk.freeCount > 0 {
-				p.freeList = append(p.freeList, bi)
-			}
-			return blk.bitToIP(idx), nil
-		}
+// Release frees an IP back to the pool. Fails with an *AddressError wrapping ErrNotInPool if ip lies outside the pool
+// network and ErrNotAllocated if it is not allocated.
+func (p *Pool) Release(ip net.IP) error {
+	u, ok := p.inIP(ip)
+	if !ok {
+		return notInPool("release", ip)
 	}
 
-	// Otherwise and if remains within limits (no IP exhaustion yet) allocate a new block
-	if p.nextBlockIndex >= p.maxBlocks {
-		return nil, fmt.Errorf("pool exhausted")
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.releaseAt("release", u)
+}
+
+// ContainsIP is the net.IP counterpart of Contains.
+func (p *Pool) ContainsIP(ip net.IP) bool {
+	u, ok := p.inIP(ip)
+	if !ok {
+		return false
 	}
 
-	blkIncoming := p.nextBlockIndex
-	// Compute first base IP of the new block
-	startBI := p.networkAddr.lsh(0).add(Uint128{Lo: blkIncoming}.lsh(p.hostBits))
-	startIP := startBI.toIP()
+	_, ok = p.blockOf(u)
+	return ok
+}
 
-	// Create the block prefix
-	prefix := net.IPNet{IP: startIP, Mask: p.blockMask}
+// IsAllocated reports whether ip is currently allocated, reserved or leased. It never changes the pool state, and
+// excluded addresses are not reported as allocated.
+func (p *Pool) IsAllocated(ip net.IP) bool {
+	u, ok := p.inIP(ip)
+	if !ok {
+		return false
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 
-	// Allocate the first IP in the new block
-	blk := newBlock(prefix, p.blockSize)
-	idx, _ := blk.allocBit()
-	ip := blk.bitToIP(idx)
+	return p.isAllocated(u)
+}
 
-	// Add the new block to the free blocks pool
-	p.blocks[blkIncoming] = blk
-	if blk.freeCount > 0 { // rare case, but possible
-		p.freeList = append(p.freeList, blkIncoming)
+// isAllocated reports whether the bit of u is set by an allocation, the caller must hold p.mu
+func (p *Pool) isAllocated(u Uint128) bool {
+	bi, ok := p.blockOf(u)
+	if !ok {
+		return false
 	}
-	p.nextBlockIndex++
-	return ip, nil
+	blk, ok := p.blocks[bi]
+	if !ok {
+		return false
+	}
+
+	idx, err := blk.offsetOf(u)
+	return err == nil && blk.isSet(idx) && !p.isExcluded(u)
 }
 
-// Release frees an IPv6 back to the pool.
-func (p *Pool) Release(ip net.IP) error {
+// Reserve marks a specific IP as allocated, so Allocate never hands it out. It is meant for well-known addresses
+// such as gateways or static assignments. The block holding ip is created if needed, even if Allocate has not reached
+// it yet. Fails with an *AddressError wrapping ErrAlreadyAllocated if ip is in use and ErrNotInPool if ip lies outside
+// the pool network.
+func (p *Pool) Reserve(ip net.IP) error {
+	u, ok := p.inIP(ip)
+	if !ok {
+		return notInPool("reserve", ip)
+	}
+
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	// Compute block base index: (ipBI - base) >> hostBits
-	ipBI := fromIP(ip)
-	delta := ipBI.sub(p.networkAddr)
-	bi := delta.rsh(p.hostBits).Lo
+	if err := p.reserve(u); err != nil {
+		return p.addressError("reserve", u, err)
+	}
+	return nil
+}
 
-	blk, ok := p.blocks[bi]
-	if !ok {
-		return fmt.Errorf("IP %s not from pool", ip)
+// reserve claims u and records it in the journal, undoing the claim if the journal fails
+func (p *Pool) reserve(u Uint128) error {
+	bi, idx, err := p.claim(u)
+	if err != nil {
+		return err
+	}
+
+	if errJournal := p.record(journalAllocate, u); errJournal != nil {
+		_ = p.free(bi, idx)
+		return errJournal
 	}
+	return nil
+}
 
-	// Retrieve the index of the IP and release it
-	idx, err := blk.ipToBit(ip)
+// releaseAt releases address u on behalf of op, returning its failure as an AddressError
+func (p *Pool) releaseAt(op string, u Uint128) error {
+	bi, idx, err := p.locate(u)
+	if err == nil {
+		err = p.release(bi, idx, u)
+	}
 	if err != nil {
+		return p.addressError(op, u, err)
+	}
+	return nil
+}
+
+// releasable returns the block index and the bit index of u if release would free it, or the error it would fail
+// with, without changing anything
+func (p *Pool) releasable(u Uint128) (uint64, uint64, error) {
+	bi, idx, err := p.locate(u)
+	if err != nil {
+		return 0, 0, err
+	}
+	if p.isExcluded(u) {
+		return 0, 0, ErrExcluded
+	}
+	if !p.blocks[bi].isSet(idx) {
+		return 0, 0, ErrNotAllocated
+	}
+	return bi, idx, nil
+}
+
+// release frees bit idx of block bi, which holds address u, records it in the journal and ends any lease on it. It is
+// the common path of Release, ReleaseAddr and the lease reaper, and tells the allocation strategy the address is
+// back.
+func (p *Pool) release(bi, idx uint64, u Uint128) error {
+	if err := p.freeRecorded(bi, idx, u); err != nil {
 		return err
 	}
-	if errRelease := blk.releaseBit(idx); errRelease != nil {
-		return errRelease
+	p.dropLease(u)
+	if p.strategy != nil {
+		p.strategy.released(u)
+	}
+	return nil
+}
+
+// freeRecorded frees bit idx of block bi, which holds address u, and records it in the journal. It also undoes
+// allocations that were journaled already, whose address the caller never saw.
+func (p *Pool) freeRecorded(bi, idx uint64, u Uint128) error {
+	if p.isExcluded(u) {
+		return ErrExcluded
+	}
+	if err := p.free(bi, idx); err != nil {
+		return err
 	}
 
-	// If block has any free space, ensure it's in freeList
-	if blk.freeCount > 0 {
-		p.freeList = append(p.freeList, bi)
+	if errJournal := p.record(journalRelease, u); errJournal != nil {
+		// Keep the IP allocated so memory and journal agree, its block may have been reclaimed meanwhile
+		_, _, _ = p.claim(u)
+		return errJournal
 	}
 	return nil
 }
+
+// allocateRecorded claims the next free bit like allocate and records its address in the journal. If the journal
+// fails the caller never sees the address, so the bit is handed back.
+func (p *Pool) allocateRecorded() (uint64, uint64, error) {
+	bi, idx, err := p.allocate()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if errJournal := p.record(journalAllocate, p.blocks[bi].base.add(Uint128{Lo: idx})); errJournal != nil {
+		_ = p.free(bi, idx)
+		return 0, 0, errJournal
+	}
+	return bi, idx, nil
+}
+
+// allocate claims the next free bit as chosen by the allocation strategy. Returns the block index and the bit index
+// within that block.
+func (p *Pool) allocate() (uint64, uint64, error) {
+	if p.strategy != nil {
+		return p.strategy.allocate(p)
+	}
+	return p.allocateLast()
+}
+
+// allocateLast claims the lowest free bit of the block listed last on the freeList, creating a new block if none is
+// listed. It is the default strategy: released addresses are handed out again first, which keeps few blocks in use.
+func (p *Pool) allocateLast() (uint64, uint64, error) {
+	bi, blk, ok := p.allocBlock()
+	if !ok {
+		return 0, 0, ErrPoolExhausted
+	}
+
+	// The block is unlisted once it fills up, and a new one is listed if it has space left
+	idx, err := blk.allocBit()
+	if err != nil {
+		return 0, 0, err
+	}
+	p.relist(bi, blk)
+	return bi, idx, nil
+}
+
+// allocBlock returns the block the next allocation is taken from: the last one on the freeList, where every block has
+// at least one free address, or a new block. Returns false if every block exists and is full.
+func (p *Pool) allocBlock() (uint64, *block, bool) {
+	if len(p.freeList) > 0 {
+		bi := p.freeList[len(p.freeList)-1]
+		return bi, p.blocks[bi], true
+	}
+
+	// Otherwise and if remains within limits (no IP exhaustion yet) create a new block
+	bi, ok := p.nextNewBlock()
+	if !ok {
+		return 0, nil, false
+	}
+	return bi, p.createBlock(bi), true
+}
+
+// claim marks a specific address as allocated, creating its block on demand even beyond nextBlockIndex. Returns the
+// block index and the bit index within that block.
+func (p *Pool) claim(u Uint128) (uint64, uint64, error) {
+	bi, ok := p.blockOf(u)
+	if !ok {
+		return 0, 0, ErrNotInPool
+	}
+	if p.isExcluded(u) {
+		return 0, 0, ErrExcluded
+	}
+
+	blk, exists := p.blocks[bi]
+	if !exists {
+		blk = p.createBlock(bi)
+	}
+
+	idx := u.sub(blk.base).Lo
+	err := blk.claimBit(idx)
+	p.relist(bi, blk)
+	if err != nil {
+		return 0, 0, err
+	}
+	return bi, idx, nil
+}
+
+// allocated returns how many addresses are in use, not counting excluded ones
+func (p *Pool) allocated() uint64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	var n uint64
+	for _, blk := range p.blocks {
+		n += blk.size - blk.freeCount - blk.excluded
+	}
+	return n
+}
+
+// blockOf returns the index of the block u falls in, or false if u lies outside the pool network
+func (p *Pool) blockOf(u Uint128) (uint64, bool) {
+	if u.cmp(p.networkAddr) < 0 {
+		return 0, false
+	}
+	bi := u.sub(p.networkAddr).rsh(p.hostBits)
+	if bi.Hi != 0 || bi.Lo >= p.maxBlocks {
+		return 0, false
+	}
+	return bi.Lo, true
+}
+
+// free clears bit idx of block bi and makes sure the block is listed in the freeList, unless it is reclaimed
+func (p *Pool) free(bi, idx uint64) error {
+	blk := p.blocks[bi]
+	if err := blk.releaseBit(idx); err != nil {
+		return err
+	}
+	if p.strategy != nil {
+		p.strategy.freed(bi)
+	}
+	if p.blockFreed(bi, blk) {
+		return nil
+	}
+	p.relist(bi, blk)
+	return nil
+}
+
+// locate returns the block index and the bit index within that block for an address. Returns ErrNotInPool if u lies
+// outside the pool network and ErrNotAllocated if its block was never created.
+func (p *Pool) locate(u Uint128) (uint64, uint64, error) {
+	bi, ok := p.blockOf(u)
+	if !ok {
+		return 0, 0, ErrNotInPool
+	}
+	blk, ok := p.blocks[bi]
+	if !ok {
+		return 0, 0, ErrNotAllocated
+	}
+
+	// blockOf already checked the range, so the offset is within the block
+	idx, err := blk.offsetOf(u)
+	if err != nil {
+		return 0, 0, err
+	}
+	return bi, idx, nil
+}
+
+// createBlock materializes the block at index bi with its excluded addresses marked. Blocks may be created out of
+// order (see claim), so nextBlockIndex is moved past any block that already exists.
+func (p *Pool) createBlock(bi uint64) *block {
+	// Compute first base IP of the new block and create the block prefix
+	startBI := p.networkAddr.add(Uint128{Lo: bi}.lsh(p.hostBits))
+	prefix := net.IPNet{IP: startBI.toIP(), Mask: p.blockMask}
+
+	blk := p.newBlock(prefix)
+	p.markExcluded(blk)
+	p.blocks[bi] = blk
+	p.advanceNextBlock()
+	return blk
+}
+
+// record appends a mutation to the journal, if the pool has one
+func (p *Pool) record(op byte, ip Uint128) error {
+	if p.journal == nil {
+		return nil
+	}
+	return p.journal.append(journalRecord(op, ip, Lease{}))
+}
+
+// recordLease appends a lease grant or renewal to the journal, if the pool has one
+func (p *Pool) recordLease(op byte, l Lease) error {
+	if p.journal == nil {
+		return nil
+	}
+	return p.journal.append(journalRecord(op, fromAddr(l.Addr), l))
+}
@@ -1,7 +1,9 @@
 package cidrx //nolint:testpackage // it's OK to be just cidrx
 
 import (
+	"errors"
 	"net"
+	"net/netip"
 	"sync"
 	"testing"
 )
@@ -119,29 +121,25 @@ func TestMultiPrefixPools(t *testing.T) {
 // TestNewPoolInvalidParams verifies that NewPool rejects bad inputs
 func TestNewPoolInvalidParams(t *testing.T) {
 	cases := []struct {
-		addr            string
-		netPrefix, blk  int
-		wantErrContains string
+		addr           string
+		netPrefix, blk int
 	}{
-		{"not-an-ip", 64, 120, "invalid IPv6"},
-		{"2001:db8::", -1, 120, "between 0 and 128"},
-		{"2001:db8::", 64, 64, "block prefix must be"},
-		{"2001:db8::", 64, 129, "block prefix must be"},
-		{"2001:db8::", 0, 64, "too many blocks"},
+		{"not-an-ip", 64, 120},
+		{"2001:db8::", -1, 120},
+		{"2001:db8::", 64, 64},
+		{"2001:db8::", 64, 129},
+		{"2001:db8::", 0, 64},
+		{"10.0.0.0", 33, 34},
+		{"10.0.0.0", 24, 24},
 	}
 
 	for _, c := range cases {
-		if _, err := NewPool(c.addr, c.netPrefix, c.blk, 1); err == nil || !contains(err.Error(), c.wantErrContains) {
-			t.Errorf("NewPool(%q, %d, %d): err %v, want contains %q",
-				c.addr, c.netPrefix, c.blk, err, c.wantErrContains)
+		if _, err := NewPool(c.addr, c.netPrefix, c.blk, 1); !errors.Is(err, ErrInvalidPrefix) {
+			t.Errorf("NewPool(%q, %d, %d): err %v, want ErrInvalidPrefix", c.addr, c.netPrefix, c.blk, err)
 		}
 	}
 }
 
-func contains(s, sub string) bool {
-	return len(s) >= len(sub) && (sub == s[:len(sub)] || sub == s[len(s)-len(sub):] || len(sub) == 0 || (len(s) > len(sub) && (contains(s[1:], sub) || contains(s[:len(s)-1], sub))))
-}
-
 // TestFreeListCapacity ensures we pre-reserved the freeList capacity
 func TestFreeListCapacity(t *testing.T) {
 	const exp = 42
@@ -266,3 +264,216 @@ func TestConcurrentUnique(t *testing.T) {
 	}
 	wg.Wait()
 }
+
+// TestReserve ensures a reserved IP is never handed out by Allocate and can be released like any other
+func TestReserve(t *testing.T) {
+	// 4 blocks of 4 addresses
+	pool, _ := NewPool("2001:db8::", 124, 126, 4)
+
+	gateway := net.ParseIP("2001:db8::1")
+	if err := pool.Reserve(gateway); err != nil {
+		t.Fatalf("Reserve error: %v", err)
+	}
+	if err := pool.Reserve(gateway); !errors.Is(err, ErrAlreadyAllocated) {
+		t.Errorf("second Reserve err = %v; want ErrAlreadyAllocated", err)
+	}
+
+	for i := 0; i < 15; i++ {
+		ip, err := pool.Allocate()
+		if err != nil {
+			t.Fatalf("Allocate #%d error: %v", i, err)
+		}
+		if ip.Equal(gateway) {
+			t.Fatalf("Allocate #%d returned reserved IP %v", i, ip)
+		}
+	}
+	if _, err := pool.Allocate(); err == nil {
+		t.Error("expected exhaustion after allocating every unreserved IP, got nil")
+	}
+
+	if err := pool.Release(gateway); err != nil {
+		t.Errorf("Release of reserved IP error: %v", err)
+	}
+}
+
+// TestReserveBeyondNextBlock ensures reserving in a block Allocate hasn't reached creates it lazily without losing
+// the blocks in between
+func TestReserveBeyondNextBlock(t *testing.T) {
+	pool, _ := NewPool("2001:db8::", 124, 126, 4)
+
+	// Block 2 covers 2001:db8::8 - 2001:db8::b
+	if err := pool.Reserve(net.ParseIP("2001:db8::a")); err != nil {
+		t.Fatalf("Reserve error: %v", err)
+	}
+	if pool.nextBlockIndex != 0 {
+		t.Errorf("nextBlockIndex = %d; want 0", pool.nextBlockIndex)
+	}
+
+	seen := make(map[string]struct{})
+	for i := 0; i < 15; i++ {
+		ip, err := pool.Allocate()
+		if err != nil {
+			t.Fatalf("Allocate #%d error: %v", i, err)
+		}
+		if _, dup := seen[ip.String()]; dup || ip.String() == "2001:db8::a" {
+			t.Fatalf("Allocate #%d returned %v twice or reserved", i, ip)
+		}
+		seen[ip.String()] = struct{}{}
+	}
+}
+
+// TestReserveOutsideNetwork ensures addresses outside the pool network are rejected
+func TestReserveOutsideNetwork(t *testing.T) {
+	pool, _ := NewPool("2001:db8::", 124, 126, 4)
+
+	for _, s := range []string{"2001:db8::10", "2001:db7:ffff::", "::", "10.0.0.1"} {
+		if err := pool.Reserve(net.ParseIP(s)); !errors.Is(err, ErrNotInPool) {
+			t.Errorf("Reserve(%s) err = %v; want ErrNotInPool", s, err)
+		}
+	}
+	if err := pool.Reserve(nil); !errors.Is(err, ErrNotInPool) {
+		t.Errorf("Reserve(nil) err = %v; want ErrNotInPool", err)
+	}
+	if err := pool.ReserveAddr(netip.MustParseAddr("2001:db8::20")); !errors.Is(err, ErrNotInPool) {
+		t.Errorf("ReserveAddr outside network err = %v; want ErrNotInPool", err)
+	}
+}
+
+// TestIsAllocated ensures IsAllocated and ContainsIP report state without changing it
+func TestIsAllocated(t *testing.T) {
+	pool, err := NewPool("2001:db8::", 64, 120, 1)
+	if err != nil {
+		t.Fatalf("NewPool error: %v", err)
+	}
+	ip, err := pool.Allocate()
+	if err != nil {
+		t.Fatalf("Allocate error: %v", err)
+	}
+
+	if !pool.IsAllocated(ip) {
+		t.Errorf("IsAllocated(%v) = false; want true", ip)
+	}
+	for _, s := range []string{"2001:db8::1", "2001:db8::ff:0", "2001:db9::"} {
+		if pool.IsAllocated(net.ParseIP(s)) {
+			t.Errorf("IsAllocated(%s) = true; want false", s)
+		}
+	}
+	if pool.IsAllocated(nil) {
+		t.Errorf("IsAllocated(nil) = true; want false")
+	}
+	if !pool.ContainsIP(net.ParseIP("2001:db8::ff:0")) || pool.ContainsIP(net.ParseIP("2001:db9::")) {
+		t.Errorf("ContainsIP does not match the pool network")
+	}
+
+	// Querying must not have changed anything
+	if errRelease := pool.Release(ip); errRelease != nil {
+		t.Fatalf("Release error: %v", errRelease)
+	}
+	if pool.IsAllocated(ip) {
+		t.Errorf("IsAllocated(%v) after Release = true; want false", ip)
+	}
+}
+
+// TestIsAllocatedConcurrent ensures queries can run alongside allocations
+func TestIsAllocatedConcurrent(t *testing.T) {
+	pool, err := NewPool("2001:db8::", 64, 120, 4)
+	if err != nil {
+		t.Fatalf("NewPool error: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	ips := make(chan net.IP, 512)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		defer close(ips)
+		for i := 0; i < 512; i++ {
+			ip, errAllocate := pool.Allocate()
+			if errAllocate != nil {
+				t.Errorf("Allocate error: %v", errAllocate)
+				return
+			}
+			ips <- ip
+		}
+	}()
+	for ip := range ips {
+		if !pool.IsAllocatedAddr(netip.AddrFrom16([16]byte(ip))) {
+			t.Errorf("IsAllocatedAddr(%v) = false; want true", ip)
+		}
+	}
+	wg.Wait()
+}
+
+// TestReleaseOutsideNetwork ensures addresses below or above the network never map onto one of its blocks
+func TestReleaseOutsideNetwork(t *testing.T) {
+	pool, err := NewPool("2001:db8:0:8000::", 65, 120, 1)
+	if err != nil {
+		t.Fatalf("NewPool error: %v", err)
+	}
+	if _, errAllocate := pool.Allocate(); errAllocate != nil {
+		t.Fatalf("Allocate error: %v", errAllocate)
+	}
+
+	// Same offset as the allocated address, one network below and one above, then far above the network
+	for _, s := range []string{"2001:db8::", "2001:db8:0:1::", "2001:db9:0:8000::", "ffff::"} {
+		ip := net.ParseIP(s)
+		if errRelease := pool.Release(ip); !errors.Is(errRelease, ErrNotInPool) {
+			t.Errorf("Release(%s) err = %v; want ErrNotInPool", s, errRelease)
+		}
+		if errRelease := pool.ReleaseAddr(netip.MustParseAddr(s)); !errors.Is(errRelease, ErrNotInPool) {
+			t.Errorf("ReleaseAddr(%s) err = %v; want ErrNotInPool", s, errRelease)
+		}
+	}
+	if n := pool.allocated(); n != 1 {
+		t.Errorf("allocated() = %d; want 1", n)
+	}
+
+	// Inside the network but in a block never created
+	if errRelease := pool.Release(net.ParseIP("2001:db8:0:8000::1:0")); !errors.Is(errRelease, ErrNotAllocated) {
+		t.Errorf("Release in uncreated block err = %v; want ErrNotAllocated", errRelease)
+	}
+}
+
+// FuzzAddressEntryPoints ensures every entry point taking an address rejects those outside the network and keeps
+// the pool consistent, whatever 128-bit value it is given
+func FuzzAddressEntryPoints(f *testing.F) {
+	f.Add(uint64(0x20010db800000000), uint64(0), false)
+	f.Add(uint64(0x20010db800000000), uint64(0xffff), true)
+	f.Add(uint64(0x20010db7ffffffff), uint64(0xffffffffffffffff), false)
+	f.Add(uint64(0x20010db900000000), uint64(0), true)
+	f.Add(uint64(0), uint64(0xffff0a000001), false)
+
+	f.Fuzz(func(t *testing.T, hi, lo uint64, reserve bool) {
+		pool, err := NewPool("2001:db8::", 64, 120, 1)
+		if err != nil {
+			t.Fatalf("NewPool error: %v", err)
+		}
+		if _, errAllocate := pool.Allocate(); errAllocate != nil {
+			t.Fatalf("Allocate error: %v", errAllocate)
+		}
+
+		u := Uint128{Hi: hi, Lo: lo}
+		addr := u.toAddr()
+		inside := hi == 0x20010db800000000 && !addr.Is4In6()
+		if got := pool.Contains(addr); got != inside {
+			t.Fatalf("Contains(%s) = %v; want %v", addr, got, inside)
+		}
+
+		var errs []error
+		if reserve {
+			errs = append(errs, pool.Reserve(u.toIP()), pool.ReserveAddr(addr))
+		}
+		errs = append(errs, pool.Release(u.toIP()), pool.ReleaseAddr(addr))
+		for _, errOp := range errs {
+			if !inside && !errors.Is(errOp, ErrNotInPool) {
+				t.Fatalf("operation on %s outside the network err = %v; want ErrNotInPool", addr, errOp)
+			}
+		}
+		if !inside && pool.IsAllocatedAddr(addr) {
+			t.Fatalf("IsAllocatedAddr(%s) = true outside the network", addr)
+		}
+		if errVerify := pool.Verify(); errVerify != nil {
+			t.Fatalf("Verify error: %v", errVerify)
+		}
+	})
+}
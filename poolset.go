@@ -0,0 +1,170 @@
+package cidrx
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+)
+
+// PoolSet aggregates several Pools over disjoint networks behind a single Allocate and Release, for address plans
+// made of non-contiguous prefixes. Allocate takes addresses from the members in address order, moving on to the next
+// one when a member is exhausted, and Release is routed to the member whose prefix holds the address.
+//
+// Members can be added while the set is in use. A member can also be drained: it stops serving Allocate but keeps
+// accepting releases, and can be removed once every address was given back.
+type PoolSet struct {
+	// members sorted by the first address of their prefix
+	members []*poolSetMember
+	// protects members; the pools have their own locks
+	mu sync.RWMutex
+}
+
+// poolSetMember is one range of a PoolSet
+type poolSetMember struct {
+	pool     *Pool
+	prefix   netip.Prefix
+	draining bool
+}
+
+// NewPoolSet constructs a PoolSet from the given pools, whose networks must not overlap.
+func NewPoolSet(pools ...*Pool) (*PoolSet, error) {
+	ps := &PoolSet{}
+	for _, p := range pools {
+		if err := ps.Add(p); err != nil {
+			return nil, err
+		}
+	}
+	return ps, nil
+}
+
+// Add adds a pool to the set. Its network must not overlap the network of any member.
+func (ps *PoolSet) Add(p *Pool) error {
+	if p == nil {
+		return fmt.Errorf("%w: pool set member must not be nil", ErrInvalidConfig)
+	}
+	prefix := p.Prefix()
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	for _, m := range ps.members {
+		if m.prefix.Overlaps(prefix) {
+			return fmt.Errorf("%w: %s and %s", ErrPrefixOverlap, prefix, m.prefix)
+		}
+	}
+
+	i, _ := slices.BinarySearchFunc(ps.members, prefix.Addr(), func(m *poolSetMember, addr netip.Addr) int {
+		return m.prefix.Addr().Compare(addr)
+	})
+	ps.members = slices.Insert(ps.members, i, &poolSetMember{pool: p, prefix: prefix})
+	return nil
+}
+
+// Drain stops Allocate from using the member covering prefix. Releases are still routed to it.
+func (ps *PoolSet) Drain(prefix netip.Prefix) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	m, _, err := ps.member(prefix)
+	if err != nil {
+		return err
+	}
+	m.draining = true
+	return nil
+}
+
+// Remove detaches the member covering prefix and returns its pool. The member must have been drained and hold no
+// allocated addresses anymore, so no address handed out by the set is left without a pool to release it to.
+func (ps *PoolSet) Remove(prefix netip.Prefix) (*Pool, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	m, i, err := ps.member(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if !m.draining {
+		return nil, fmt.Errorf("%w: range %s must be drained before it is removed", ErrRangeInUse, prefix)
+	}
+	if n := m.pool.allocated(); n != 0 {
+		return nil, fmt.Errorf("%w: range %s still holds %d allocated addresses", ErrRangeInUse, prefix, n)
+	}
+
+	ps.members = slices.Delete(ps.members, i, i+1)
+	return m.pool, nil
+}
+
+// Prefixes returns the networks of every member in address order.
+func (ps *PoolSet) Prefixes() []netip.Prefix {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	out := make([]netip.Prefix, 0, len(ps.members))
+	for _, m := range ps.members {
+		out = append(out, m.prefix)
+	}
+	return out
+}
+
+// Allocate returns a free IP from the first member, in address order, that is not drained nor exhausted. Returns
+// ErrPoolExhausted if there is none.
+func (ps *PoolSet) Allocate() (net.IP, error) {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	for _, m := range ps.members {
+		if m.draining {
+			continue
+		}
+		ip, err := m.pool.Allocate()
+		if !errors.Is(err, ErrPoolExhausted) {
+			return ip, err
+		}
+	}
+	return nil, ErrPoolExhausted
+}
+
+// Release frees an IP back to the member whose network holds it.
+func (ps *PoolSet) Release(ip net.IP) error {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return notInPool("release", ip)
+	}
+
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+
+	m := ps.lookup(addr.Unmap())
+	if m == nil {
+		return notInPool("release", ip)
+	}
+	return m.pool.Release(ip)
+}
+
+// lookup returns the member whose network holds addr, or nil
+func (ps *PoolSet) lookup(addr netip.Addr) *poolSetMember {
+	// The candidate is the last member starting at or before addr, members don't overlap
+	i, found := slices.BinarySearchFunc(ps.members, addr, func(m *poolSetMember, a netip.Addr) int {
+		return m.prefix.Addr().Compare(a)
+	})
+	if !found {
+		i--
+	}
+	if i < 0 || !ps.members[i].prefix.Contains(addr) {
+		return nil
+	}
+	return ps.members[i]
+}
+
+// member returns the member with exactly the given network and its position
+func (ps *PoolSet) member(prefix netip.Prefix) (*poolSetMember, int, error) {
+	for i, m := range ps.members {
+		if m.prefix == prefix.Masked() {
+			return m, i, nil
+		}
+	}
+	return nil, 0, fmt.Errorf("%w: no range %s", ErrNotInPool, prefix)
+}
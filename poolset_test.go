@@ -0,0 +1,119 @@
+package cidrx //nolint:testpackage // it's OK to be just cidrx
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+)
+
+// TestPoolSetOverlap ensures members can't share addresses
+func TestPoolSetOverlap(t *testing.T) {
+	// One address per block in every pool below
+	wide, _ := NewPool("2001:db8:1::", 126, 128, 1)
+	ps, err := NewPoolSet(wide)
+	if err != nil {
+		t.Fatalf("NewPoolSet error: %v", err)
+	}
+	narrow, _ := NewPool("2001:db8:1::", 127, 128, 1)
+	if errAdd := ps.Add(narrow); !errors.Is(errAdd, ErrPrefixOverlap) {
+		t.Errorf("Add overlapping range err = %v; want ErrPrefixOverlap", errAdd)
+	}
+	v4Wide, _ := NewPool("10.0.0.0", 30, 32, 1)
+	v4Narrow, _ := NewPool("10.0.0.2", 31, 32, 1)
+	if _, errNew := NewPoolSet(v4Wide, v4Narrow); !errors.Is(errNew, ErrPrefixOverlap) {
+		t.Errorf("NewPoolSet with overlapping ranges err = %v; want ErrPrefixOverlap", errNew)
+	}
+}
+
+// TestPoolSetFailover ensures Allocate moves through the ranges in address order and fails once all are exhausted
+func TestPoolSetFailover(t *testing.T) {
+	// Added out of order, each range holds two addresses
+	seven, _ := NewPool("2001:db8:7::", 127, 128, 1)
+	one, _ := NewPool("2001:db8:1::", 127, 128, 1)
+	ps, _ := NewPoolSet(seven, one)
+
+	for _, want := range []string{"2001:db8:1::", "2001:db8:1::1", "2001:db8:7::"} {
+		ip, err := ps.Allocate()
+		if err != nil || !ip.Equal(net.ParseIP(want)) {
+			t.Fatalf("Allocate = %v, %v; want %s", ip, err, want)
+		}
+	}
+
+	// A range added at runtime is used once the earlier ones are full
+	nine, _ := NewPool("2001:db8:9::", 127, 128, 1)
+	if err := ps.Add(nine); err != nil {
+		t.Fatalf("Add error: %v", err)
+	}
+	for _, want := range []string{"2001:db8:7::1", "2001:db8:9::", "2001:db8:9::1"} {
+		ip, err := ps.Allocate()
+		if err != nil || !ip.Equal(net.ParseIP(want)) {
+			t.Fatalf("Allocate = %v, %v; want %s", ip, err, want)
+		}
+	}
+	if _, err := ps.Allocate(); !errors.Is(err, ErrPoolExhausted) {
+		t.Errorf("Allocate on full set err = %v; want ErrPoolExhausted", err)
+	}
+}
+
+// TestPoolSetRelease ensures releases reach the member holding the address, whatever the family
+func TestPoolSetRelease(t *testing.T) {
+	seven, _ := NewPool("2001:db8:7::", 127, 128, 1)
+	v4, _ := NewPool("10.0.0.0", 31, 32, 1)
+	one, _ := NewPool("2001:db8:1::", 127, 128, 1)
+	ps, _ := NewPoolSet(seven, v4, one)
+	ips := make([]net.IP, 6)
+	for i := range ips {
+		ips[i], _ = ps.Allocate()
+	}
+
+	for _, ip := range ips {
+		if err := ps.Release(ip); err != nil {
+			t.Errorf("Release(%v) error: %v", ip, err)
+		}
+	}
+	for _, s := range []string{"2001:db8:2::", "2001:db8:7::2", "10.0.0.2", "::"} {
+		if err := ps.Release(net.ParseIP(s)); err == nil {
+			t.Errorf("Release(%s): expected error, got nil", s)
+		}
+	}
+	if err := ps.Release(nil); err == nil {
+		t.Error("Release(nil): expected error, got nil")
+	}
+}
+
+// TestPoolSetDrain ensures drained ranges serve no allocations and can only be removed once empty
+func TestPoolSetDrain(t *testing.T) {
+	first := netip.MustParsePrefix("2001:db8:1::/127")
+	one, _ := NewPoolFromPrefix(first, 128, 1)
+	seven, _ := NewPool("2001:db8:7::", 127, 128, 1)
+	ps, _ := NewPoolSet(one, seven)
+	held, _ := ps.Allocate()
+
+	if _, err := ps.Remove(first); err == nil {
+		t.Error("Remove of an undrained range: expected error, got nil")
+	}
+	if err := ps.Drain(first); err != nil {
+		t.Fatalf("Drain error: %v", err)
+	}
+	if ip, _ := ps.Allocate(); !ip.Equal(net.ParseIP("2001:db8:7::")) {
+		t.Errorf("Allocate after Drain = %v; want 2001:db8:7::", ip)
+	}
+	if _, err := ps.Remove(first); err == nil {
+		t.Error("Remove of a range with allocated addresses: expected error, got nil")
+	}
+
+	if err := ps.Release(held); err != nil {
+		t.Fatalf("Release to drained range error: %v", err)
+	}
+	p, err := ps.Remove(first)
+	if err != nil || p.Prefix() != first {
+		t.Fatalf("Remove = %v, %v; want the pool of %s", p, err, first)
+	}
+	if got := ps.Prefixes(); len(got) != 1 || got[0] != netip.MustParsePrefix("2001:db8:7::/127") {
+		t.Errorf("Prefixes after Remove = %v", got)
+	}
+	if err := ps.Drain(first); !errors.Is(err, ErrNotInPool) {
+		t.Errorf("Drain of removed range err = %v; want ErrNotInPool", err)
+	}
+}
@@ -0,0 +1,138 @@
+package cidrx
+
+import (
+	"fmt"
+	"maps"
+	"net/netip"
+	"slices"
+	"sync"
+)
+
+// PrefixPool delegates whole sub-prefixes of a parent IPv6 network, for example /56 or /64 customer prefixes carved
+// out of a /48 for DHCPv6 prefix delegation. Prefixes of mixed lengths can be delegated from the same parent and
+// never overlap.
+//
+// It is a buddy allocator: free space is kept as power-of-two prefixes grouped by length. A request is served from the
+// smallest free prefix large enough, splitting it in halves until it has the requested length, and a released prefix
+// is merged back with its buddy (the other half of its parent) whenever that one is free too.
+type PrefixPool struct {
+	// parent network the prefixes are carved from
+	parent netip.Prefix
+
+	// free[l] holds the first address of each free prefix of length l, sorted. Lengths shorter than the parent's
+	// are never used.
+	free [ipv6BitLen + 1][]Uint128
+	// maps the first address of each delegated prefix to its length
+	delegated map[Uint128]int
+	// protects free and delegated
+	mu sync.Mutex
+}
+
+// NewPrefixPool constructs a PrefixPool that delegates sub-prefixes of the given IPv6 network. Host bits set in
+// parent are ignored.
+func NewPrefixPool(parent netip.Prefix) (*PrefixPool, error) {
+	if !parent.IsValid() || !parent.Addr().Is6() || parent.Addr().Is4In6() {
+		return nil, fmt.Errorf("%w: %s is not an IPv6 prefix", ErrInvalidPrefix, parent)
+	}
+
+	parent = parent.Masked()
+	pp := &PrefixPool{
+		parent:    parent,
+		delegated: make(map[Uint128]int),
+	}
+	// Initially the whole parent is one free prefix
+	pp.free[parent.Bits()] = []Uint128{fromAddr(parent.Addr())}
+	return pp, nil
+}
+
+// Parent returns the network prefixes are delegated from.
+func (pp *PrefixPool) Parent() netip.Prefix {
+	return pp.parent
+}
+
+// AllocatePrefix delegates a free prefix of the given length, which must lie between the parent prefix length and
+// 128. Among the free prefixes that fit, the smallest one at the lowest address is used.
+func (pp *PrefixPool) AllocatePrefix(length int) (netip.Prefix, error) {
+	if length < pp.parent.Bits() || length > ipv6BitLen {
+		return netip.Prefix{}, fmt.Errorf("%w: length must be between /%d and /%d", ErrInvalidPrefix,
+			pp.parent.Bits(), ipv6BitLen)
+	}
+
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+
+	// Find the longest (smallest) free prefix that can hold the request
+	l := length
+	for l >= pp.parent.Bits() && len(pp.free[l]) == 0 {
+		l--
+	}
+	if l < pp.parent.Bits() {
+		return netip.Prefix{}, ErrPoolExhausted
+	}
+
+	start := pp.free[l][0]
+	pp.free[l] = slices.Delete(pp.free[l], 0, 1)
+
+	// Split it, keeping the lower half and freeing the upper one, until it has the requested length
+	for ; l < length; l++ {
+		pp.insertFree(l+1, start.xor(buddyBit(l+1)))
+	}
+
+	pp.delegated[start] = length
+	return netip.PrefixFrom(start.toAddr(), length), nil
+}
+
+// ReleasePrefix returns a prefix obtained from AllocatePrefix to the pool, merging it with its free buddies.
+func (pp *PrefixPool) ReleasePrefix(prefix netip.Prefix) error {
+	if !prefix.IsValid() || !pp.parent.Contains(prefix.Addr()) || prefix.Bits() < pp.parent.Bits() {
+		return fmt.Errorf("%w: %s", ErrNotInPool, prefix)
+	}
+
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+
+	start := fromAddr(prefix.Addr())
+	l, ok := pp.delegated[start]
+	if !ok || l != prefix.Bits() {
+		return fmt.Errorf("%w: %s", ErrPrefixNotDelegated, prefix)
+	}
+	delete(pp.delegated, start)
+
+	// Coalesce with the buddy as long as it is free, moving one level up each time
+	for ; l > pp.parent.Bits(); l-- {
+		bit := buddyBit(l)
+		i, found := slices.BinarySearchFunc(pp.free[l], start.xor(bit), Uint128.cmp)
+		if !found {
+			break
+		}
+		pp.free[l] = slices.Delete(pp.free[l], i, i+1)
+		start = start.andNot(bit)
+	}
+
+	pp.insertFree(l, start)
+	return nil
+}
+
+// Delegated returns the currently delegated prefixes in address order.
+func (pp *PrefixPool) Delegated() []netip.Prefix {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+
+	out := make([]netip.Prefix, 0, len(pp.delegated))
+	for _, start := range slices.SortedFunc(maps.Keys(pp.delegated), Uint128.cmp) {
+		out = append(out, netip.PrefixFrom(start.toAddr(), pp.delegated[start]))
+	}
+	return out
+}
+
+// insertFree adds the prefix of the given length starting at start to the free lists, keeping them sorted
+func (pp *PrefixPool) insertFree(length int, start Uint128) {
+	i, _ := slices.BinarySearchFunc(pp.free[length], start, Uint128.cmp)
+	pp.free[length] = slices.Insert(pp.free[length], i, start)
+}
+
+// buddyBit returns the address bit that tells apart the two halves of a prefix one bit shorter than length, that is,
+// a prefix of the given length and its buddy (0<length<=128)
+func buddyBit(length int) Uint128 {
+	return Uint128{Lo: 1}.lsh(uint(ipv6BitLen - length))
+}
@@ -0,0 +1,193 @@
+package cidrx //nolint:testpackage // it's OK to be just cidrx
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+)
+
+// TestPrefixPoolAllocate ensures prefixes are delegated in address order and never overlap
+func TestPrefixPoolAllocate(t *testing.T) {
+	pp, err := NewPrefixPool(netip.MustParsePrefix("2001:db8::/48"))
+	if err != nil {
+		t.Fatalf("NewPrefixPool error: %v", err)
+	}
+
+	want := []string{"2001:db8::/56", "2001:db8:0:100::/56", "2001:db8:0:200::/56"}
+	for i, w := range want {
+		got, errAlloc := pp.AllocatePrefix(56)
+		if errAlloc != nil {
+			t.Fatalf("AllocatePrefix #%d error: %v", i, errAlloc)
+		}
+		if got.String() != w {
+			t.Errorf("AllocatePrefix #%d = %s; want %s", i, got, w)
+		}
+	}
+
+	// A /64 lands right after the /56s, a /56 after that has to skip the rest of the /56 the /64 sits in
+	if got, _ := pp.AllocatePrefix(64); got.String() != "2001:db8:0:300::/64" {
+		t.Errorf("AllocatePrefix(64) = %s; want 2001:db8:0:300::/64", got)
+	}
+	if got, _ := pp.AllocatePrefix(56); got.String() != "2001:db8:0:400::/56" {
+		t.Errorf("AllocatePrefix(56) = %s; want 2001:db8:0:400::/56", got)
+	}
+
+	delegated := pp.Delegated()
+	for i := range delegated {
+		for j := i + 1; j < len(delegated); j++ {
+			if delegated[i].Overlaps(delegated[j]) {
+				t.Errorf("delegated prefixes %s and %s overlap", delegated[i], delegated[j])
+			}
+		}
+	}
+}
+
+// TestPrefixPoolRelease ensures a released prefix is delegated again and unknown prefixes are rejected
+func TestPrefixPoolRelease(t *testing.T) {
+	pp, _ := NewPrefixPool(netip.MustParsePrefix("2001:db8::/48"))
+	first, _ := pp.AllocatePrefix(56)
+	_, _ = pp.AllocatePrefix(56)
+
+	if err := pp.ReleasePrefix(first); err != nil {
+		t.Fatalf("ReleasePrefix error: %v", err)
+	}
+	if err := pp.ReleasePrefix(first); !errors.Is(err, ErrPrefixNotDelegated) {
+		t.Errorf("double ReleasePrefix err = %v; want ErrPrefixNotDelegated", err)
+	}
+	if again, _ := pp.AllocatePrefix(56); again != first {
+		t.Errorf("AllocatePrefix after release = %s; want %s", again, first)
+	}
+
+	// Same start, different length is not the delegated prefix
+	if err := pp.ReleasePrefix(netip.MustParsePrefix("2001:db8::/64")); !errors.Is(err, ErrPrefixNotDelegated) {
+		t.Errorf("ReleasePrefix with wrong length err = %v; want ErrPrefixNotDelegated", err)
+	}
+	if err := pp.ReleasePrefix(netip.MustParsePrefix("2001:db9::/56")); !errors.Is(err, ErrNotInPool) {
+		t.Errorf("ReleasePrefix outside parent err = %v; want ErrNotInPool", err)
+	}
+}
+
+// TestPrefixPoolExhaustion ensures exhaustion is reported once the parent is fully delegated
+func TestPrefixPoolExhaustion(t *testing.T) {
+	pp, _ := NewPrefixPool(netip.MustParsePrefix("2001:db8::/62"))
+	for i := 0; i < 4; i++ {
+		if _, err := pp.AllocatePrefix(64); err != nil {
+			t.Fatalf("AllocatePrefix #%d error: %v", i, err)
+		}
+	}
+	if _, err := pp.AllocatePrefix(64); !errors.Is(err, ErrPoolExhausted) {
+		t.Errorf("AllocatePrefix on full pool err = %v; want ErrPoolExhausted", err)
+	}
+	if _, err := pp.AllocatePrefix(128); !errors.Is(err, ErrPoolExhausted) {
+		t.Errorf("AllocatePrefix(128) on full pool err = %v; want ErrPoolExhausted", err)
+	}
+}
+
+// TestPrefixPoolWholeSpace ensures the arithmetic holds at the top of the address space
+func TestPrefixPoolWholeSpace(t *testing.T) {
+	pp, err := NewPrefixPool(netip.MustParsePrefix("::/0"))
+	if err != nil {
+		t.Fatalf("NewPrefixPool error: %v", err)
+	}
+	if got, _ := pp.AllocatePrefix(1); got.String() != "::/1" {
+		t.Errorf("AllocatePrefix(1) = %s; want ::/1", got)
+	}
+	if got, _ := pp.AllocatePrefix(1); got.String() != "8000::/1" {
+		t.Errorf("AllocatePrefix(1) = %s; want 8000::/1", got)
+	}
+	if _, errAlloc := pp.AllocatePrefix(128); !errors.Is(errAlloc, ErrPoolExhausted) {
+		t.Errorf("AllocatePrefix(128) err = %v; want ErrPoolExhausted", errAlloc)
+	}
+}
+
+// TestPrefixPoolInvalid ensures bad parents and lengths are rejected
+func TestPrefixPoolInvalid(t *testing.T) {
+	if _, err := NewPrefixPool(netip.MustParsePrefix("10.0.0.0/8")); err == nil {
+		t.Error("NewPrefixPool(IPv4): expected error, got nil")
+	}
+	pp, _ := NewPrefixPool(netip.MustParsePrefix("2001:db8::/48"))
+	for _, length := range []int{-1, 47, 129} {
+		if _, err := pp.AllocatePrefix(length); err == nil {
+			t.Errorf("AllocatePrefix(%d): expected error, got nil", length)
+		}
+	}
+}
+
+// TestPrefixPoolMixedLengths ensures mixed sizes share one parent without overlapping
+func TestPrefixPoolMixedLengths(t *testing.T) {
+	pp, _ := NewPrefixPool(netip.MustParsePrefix("2001:db8::/56"))
+
+	var got []netip.Prefix
+	for _, length := range []int{124, 60, 64, 124, 124, 64, 60} {
+		prefix, err := pp.AllocatePrefix(length)
+		if err != nil {
+			t.Fatalf("AllocatePrefix(%d) error: %v", length, err)
+		}
+		if prefix.Bits() != length || prefix != prefix.Masked() {
+			t.Errorf("AllocatePrefix(%d) = %s; want an aligned /%d", length, prefix, length)
+		}
+		got = append(got, prefix)
+	}
+
+	for i := range got {
+		for j := i + 1; j < len(got); j++ {
+			if got[i].Overlaps(got[j]) {
+				t.Errorf("%s and %s overlap", got[i], got[j])
+			}
+		}
+	}
+	if len(pp.Delegated()) != len(got) {
+		t.Errorf("Delegated() has %d prefixes; want %d", len(pp.Delegated()), len(got))
+	}
+}
+
+// TestPrefixPoolCoalesce ensures freeing buddies merges them back into their parent
+func TestPrefixPoolCoalesce(t *testing.T) {
+	pp, _ := NewPrefixPool(netip.MustParsePrefix("2001:db8::/62"))
+
+	quarters := make([]netip.Prefix, 4)
+	for i := range quarters {
+		quarters[i], _ = pp.AllocatePrefix(64)
+	}
+	if _, err := pp.AllocatePrefix(63); !errors.Is(err, ErrPoolExhausted) {
+		t.Fatalf("AllocatePrefix(63) on full pool err = %v; want ErrPoolExhausted", err)
+	}
+
+	// Freeing non-buddies (first and third quarter) does not make room for a /63
+	_ = pp.ReleasePrefix(quarters[0])
+	_ = pp.ReleasePrefix(quarters[2])
+	if _, err := pp.AllocatePrefix(63); !errors.Is(err, ErrPoolExhausted) {
+		t.Errorf("AllocatePrefix(63) with fragmented space err = %v; want ErrPoolExhausted", err)
+	}
+
+	// Freeing the second quarter completes the first /63
+	_ = pp.ReleasePrefix(quarters[1])
+	half, err := pp.AllocatePrefix(63)
+	if err != nil || half.String() != "2001:db8::/63" {
+		t.Fatalf("AllocatePrefix(63) = %s, %v; want 2001:db8::/63", half, err)
+	}
+
+	// Releasing everything restores the parent as a single free prefix
+	_ = pp.ReleasePrefix(half)
+	_ = pp.ReleasePrefix(quarters[3])
+	whole, err := pp.AllocatePrefix(62)
+	if err != nil || whole != pp.Parent() {
+		t.Errorf("AllocatePrefix(62) = %s, %v; want %s", whole, err, pp.Parent())
+	}
+}
+
+// TestPrefixPoolSmallestFit ensures a request is served from the smallest free prefix, leaving large ones intact
+func TestPrefixPoolSmallestFit(t *testing.T) {
+	pp, _ := NewPrefixPool(netip.MustParsePrefix("2001:db8::/60"))
+
+	// Leaves a free /61, /62, /63 and /64 behind
+	_, _ = pp.AllocatePrefix(64)
+	// Served from the free /64 right after it, not by splitting the /61
+	next, _ := pp.AllocatePrefix(64)
+	if next.String() != "2001:db8:0:1::/64" {
+		t.Errorf("second /64 = %s; want 2001:db8:0:1::/64", next)
+	}
+	if big, err := pp.AllocatePrefix(61); err != nil || big.String() != "2001:db8:0:8::/61" {
+		t.Errorf("AllocatePrefix(61) = %s, %v; want 2001:db8:0:8::/61", big, err)
+	}
+}
@@ -0,0 +1,158 @@
+package cidrx
+
+import (
+	"cmp"
+	"fmt"
+	"net"
+	"slices"
+	"time"
+)
+
+// idleBlock records when a block was left without allocations
+type idleBlock struct {
+	bi    uint64
+	since time.Time
+}
+
+// WithBlockReclaim drops blocks once every address in them was released, returning their bitmap memory. A block is
+// dropped as soon as it empties when grace is zero, otherwise once it stayed empty for grace; idle blocks are checked
+// on every release. Dropped block indices are reused before new blocks are created. Compact reclaims empty blocks
+// whether or not this option is set.
+func WithBlockReclaim(grace time.Duration) Option {
+	return func(p *Pool) {
+		if grace < 0 {
+			p.optErr = fmt.Errorf("%w: block reclaim grace period must not be negative, got %s", ErrInvalidConfig, grace)
+			return
+		}
+		p.reclaim = true
+		p.reclaimGrace = grace
+		p.idleSince = make(map[uint64]time.Time)
+	}
+}
+
+// WithBitmapRecycling keeps the bitmaps of reclaimed blocks, up to maxBytes of them, and uses them for the next blocks
+// created instead of allocating new ones. A reclaimed bitmap is already zeroed, so a large block is created again
+// without paying for a fresh allocation and its zeroing. It only has an effect with WithBlockReclaim or Compact.
+func WithBitmapRecycling(maxBytes uint64) Option {
+	return func(p *Pool) {
+		p.spareMax = maxBytes
+	}
+}
+
+// Compact drops every block that holds no allocated address, ignoring any grace period, and returns how many were
+// dropped.
+func (p *Pool) Compact() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	n := 0
+	for bi, blk := range p.blocks {
+		if blk.empty() {
+			p.dropBlock(bi)
+			n++
+		}
+	}
+
+	p.idle = p.idle[:0]
+	clear(p.idleSince)
+	return n
+}
+
+// blockFreed is called when block bi lost an allocation. Returns true if the block was dropped.
+func (p *Pool) blockFreed(bi uint64, blk *block) bool {
+	if !p.reclaim {
+		return false
+	}
+
+	dropped := false
+	if blk.empty() {
+		if p.reclaimGrace == 0 {
+			p.dropBlock(bi)
+			dropped = true
+		} else {
+			now := p.now()
+			p.idleSince[bi] = now
+			p.idle = append(p.idle, idleBlock{bi: bi, since: now})
+		}
+	}
+	p.reclaimIdle()
+	return dropped
+}
+
+// reclaimIdle drops the blocks that have been empty for the whole grace period. Entries are queued in the order blocks
+// emptied, so only the front of the queue needs checking; entries of blocks that were used again or emptied once more
+// since are skipped.
+func (p *Pool) reclaimIdle() {
+	now := p.now()
+	i := 0
+	for ; i < len(p.idle) && now.Sub(p.idle[i].since) >= p.reclaimGrace; i++ {
+		e := p.idle[i]
+		if since, ok := p.idleSince[e.bi]; !ok || !since.Equal(e.since) {
+			continue
+		}
+		delete(p.idleSince, e.bi)
+		if blk, ok := p.blocks[e.bi]; ok && blk.empty() {
+			p.dropBlock(e.bi)
+		}
+	}
+	p.idle = slices.Delete(p.idle, 0, i)
+}
+
+// dropBlock removes block bi and its freeList entry, remembering the index so allocate creates it again before any new
+// one
+func (p *Pool) dropBlock(bi uint64) {
+	blk := p.blocks[bi]
+	p.unlist(blk)
+	delete(p.blocks, bi)
+	if bi < p.nextBlockIndex {
+		// Kept in descending order so allocate takes the lowest index from the end
+		i, _ := slices.BinarySearchFunc(p.reclaimed, bi, func(a, b uint64) int { return cmp.Compare(b, a) })
+		p.reclaimed = slices.Insert(p.reclaimed, i, bi)
+	}
+	p.recycle(blk)
+}
+
+// recycle keeps the empty block blk for newBlock if the spare bitmaps stay within spareMax
+func (p *Pool) recycle(blk *block) {
+	size := blk.bitmapBytes()
+	if p.spareBytes+size > p.spareMax {
+		return
+	}
+
+	// Excluded addresses are the only bits still set, clear them so the bitmap is all zeros again
+	p.excludedSpans(blk.base, blk.size, func(from, to uint64) {
+		for wi := from / 64; wi <= to/64; wi++ {
+			blk.used[wi] &^= spanMask(wi, from, to)
+		}
+	})
+	p.spare = append(p.spare, blk)
+	p.spareBytes += size
+}
+
+// newBlock returns an empty block for prefix, reusing a recycled bitmap when there is one
+func (p *Pool) newBlock(prefix net.IPNet) *block {
+	if len(p.spare) == 0 {
+		return newBlock(prefix, p.blockSize)
+	}
+
+	blk := p.spare[len(p.spare)-1]
+	p.spare[len(p.spare)-1] = nil
+	p.spare = p.spare[:len(p.spare)-1]
+	p.spareBytes -= blk.bitmapBytes()
+	blk.reuse(prefix)
+	return blk
+}
+
+// nextNewBlock returns the index of the block allocate creates next, preferring reclaimed indices, or false if every
+// block exists
+func (p *Pool) nextNewBlock() (uint64, bool) {
+	for len(p.reclaimed) > 0 {
+		bi := p.reclaimed[len(p.reclaimed)-1]
+		p.reclaimed = p.reclaimed[:len(p.reclaimed)-1]
+		// Reserve may have created it again in the meantime
+		if _, exists := p.blocks[bi]; !exists {
+			return bi, true
+		}
+	}
+	return p.nextBlockIndex, p.nextBlockIndex < p.maxBlocks
+}
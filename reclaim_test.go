@@ -0,0 +1,236 @@
+package cidrx //nolint:testpackage // it's OK to be just cidrx
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+)
+
+// fillBlocks allocates n addresses and returns them
+func fillBlocks(t *testing.T, pool *Pool, n int) []netip.Addr {
+	t.Helper()
+	addrs := make([]netip.Addr, 0, n)
+	for i := 0; i < n; i++ {
+		addr, err := pool.AllocateAddr()
+		if err != nil {
+			t.Fatalf("AllocateAddr #%d error: %v", i, err)
+		}
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+// TestReclaimImmediate ensures a block is dropped as soon as it empties and its index is reused first
+func TestReclaimImmediate(t *testing.T) {
+	// /124 blocks => 16 addresses each
+	pool, err := NewPool("2001:db8::", 64, 124, 4, WithBlockReclaim(0))
+	if err != nil {
+		t.Fatalf("NewPool error: %v", err)
+	}
+	addrs := fillBlocks(t, pool, 48)
+
+	// Empty the middle block
+	for _, addr := range addrs[16:32] {
+		if errRelease := pool.ReleaseAddr(addr); errRelease != nil {
+			t.Fatalf("ReleaseAddr error: %v", errRelease)
+		}
+	}
+	if _, ok := pool.blocks[1]; ok {
+		t.Fatalf("block 1 still present after every address was released")
+	}
+	if n := len(pool.blocks); n != 2 {
+		t.Errorf("pool holds %d blocks; want 2", n)
+	}
+
+	// The dropped block is created again before block 3
+	addr, err := pool.AllocateAddr()
+	if err != nil {
+		t.Fatalf("AllocateAddr error: %v", err)
+	}
+	if want := netip.MustParseAddr("2001:db8::10"); addr != want {
+		t.Errorf("AllocateAddr = %s; want %s", addr, want)
+	}
+}
+
+// TestReclaimGrace ensures an empty block is only dropped once the grace period has passed
+func TestReclaimGrace(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	pool, err := NewPool("2001:db8::", 64, 124, 4, WithClock(clock.now), WithBlockReclaim(time.Minute))
+	if err != nil {
+		t.Fatalf("NewPool error: %v", err)
+	}
+	addrs := fillBlocks(t, pool, 17)
+
+	for _, addr := range addrs[:16] {
+		if errRelease := pool.ReleaseAddr(addr); errRelease != nil {
+			t.Fatalf("ReleaseAddr error: %v", errRelease)
+		}
+	}
+	if _, ok := pool.blocks[0]; !ok {
+		t.Fatalf("block 0 dropped before the grace period")
+	}
+
+	// Reusing the block and emptying it again restarts its grace period
+	clock.advance(50 * time.Second)
+	reused, err := pool.AllocateAddr()
+	if err != nil {
+		t.Fatalf("AllocateAddr error: %v", err)
+	}
+	if errRelease := pool.ReleaseAddr(reused); errRelease != nil {
+		t.Fatalf("ReleaseAddr error: %v", errRelease)
+	}
+	clock.advance(50 * time.Second)
+	if errRelease := pool.ReleaseAddr(addrs[16]); errRelease != nil {
+		t.Fatalf("ReleaseAddr error: %v", errRelease)
+	}
+	if _, ok := pool.blocks[0]; !ok {
+		t.Fatalf("block 0 dropped although it was emptied again 50s ago")
+	}
+
+	// Once the grace period is over only the block used and emptied again just now is kept
+	clock.advance(time.Minute)
+	addr, err := pool.AllocateAddr()
+	if err != nil {
+		t.Fatalf("AllocateAddr error: %v", err)
+	}
+	if errRelease := pool.ReleaseAddr(addr); errRelease != nil {
+		t.Fatalf("ReleaseAddr error: %v", errRelease)
+	}
+	bi, _ := pool.blockOf(fromAddr(addr))
+	if _, ok := pool.blocks[bi]; !ok || len(pool.blocks) != 1 {
+		t.Errorf("pool holds %d blocks after the grace period, block %d present %v; want only it", len(pool.blocks), bi, ok)
+	}
+
+	if _, errNew := NewPool("2001:db8::", 64, 124, 4, WithBlockReclaim(-time.Second)); errNew == nil {
+		t.Errorf("negative grace period: expected error, got nil")
+	}
+}
+
+// TestCompact ensures Compact drops every empty block even without WithBlockReclaim
+func TestCompact(t *testing.T) {
+	pool, err := NewPool("10.0.0.0", 24, 28, 4)
+	if err != nil {
+		t.Fatalf("NewPool error: %v", err)
+	}
+	addrs := fillBlocks(t, pool, 40)
+	for _, addr := range append(addrs[:16], addrs[32:]...) {
+		if errRelease := pool.ReleaseAddr(addr); errRelease != nil {
+			t.Fatalf("ReleaseAddr error: %v", errRelease)
+		}
+	}
+
+	if n := pool.Compact(); n != 2 {
+		t.Errorf("Compact() = %d; want 2", n)
+	}
+	if st := pool.Stats(); st.Blocks != 1 || st.Allocated != 16 || st.FreeListLen != 0 {
+		t.Errorf("Stats() after Compact = %+v; want 1 full block", st)
+	}
+
+	// The lowest reclaimed index is created again first
+	for _, want := range []string{"10.0.0.0", "10.0.0.1"} {
+		addr, errAllocate := pool.AllocateAddr()
+		if errAllocate != nil {
+			t.Fatalf("AllocateAddr error: %v", errAllocate)
+		}
+		if addr != netip.MustParseAddr(want) {
+			t.Errorf("AllocateAddr = %s; want %s", addr, want)
+		}
+	}
+}
+
+// TestSnapshotOmitsEmptyBlocks ensures empty blocks are left out of snapshots and created again after a restore
+func TestSnapshotOmitsEmptyBlocks(t *testing.T) {
+	pool, err := NewPool("2001:db8::", 64, 124, 4)
+	if err != nil {
+		t.Fatalf("NewPool error: %v", err)
+	}
+	addrs := fillBlocks(t, pool, 40)
+	for _, addr := range addrs[:16] {
+		if errRelease := pool.ReleaseAddr(addr); errRelease != nil {
+			t.Fatalf("ReleaseAddr error: %v", errRelease)
+		}
+	}
+
+	s := pool.Snapshot()
+	if _, ok := s.Blocks[0]; ok || len(s.Blocks) != 2 {
+		t.Fatalf("snapshot blocks = %d, block 0 present %v; want the empty block 0 left out", len(s.Blocks), ok)
+	}
+	for _, bi := range s.FreeList {
+		if bi == 0 {
+			t.Fatalf("snapshot free list references the omitted block")
+		}
+	}
+	data, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary error: %v", err)
+	}
+	var decoded Snapshot
+	if errUnmarshal := decoded.UnmarshalBinary(data); errUnmarshal != nil {
+		t.Fatalf("UnmarshalBinary error: %v", errUnmarshal)
+	}
+
+	restored, err := NewPoolFromSnapshot(&decoded)
+	if err != nil {
+		t.Fatalf("NewPoolFromSnapshot error: %v", err)
+	}
+	seen := make(map[netip.Addr]bool)
+	for addr := range restored.Allocated() {
+		seen[addr] = true
+	}
+	for {
+		addr, errAllocate := restored.AllocateAddr()
+		if errAllocate != nil {
+			break
+		}
+		if seen[addr] {
+			t.Fatalf("restored pool handed out %s twice", addr)
+		}
+		seen[addr] = true
+		if len(seen) == 64 {
+			break
+		}
+	}
+	if !seen[netip.MustParseAddr("2001:db8::")] {
+		t.Errorf("address of the omitted block never handed out again")
+	}
+}
+
+// TestBitmapRecycling ensures reclaimed bitmaps back new blocks, within the configured cap, with exclusions redone
+func TestBitmapRecycling(t *testing.T) {
+	// /124 blocks => one bitmap word and one summary word, 16 bytes each
+	pool, err := NewPool("2001:db8::", 64, 124, 4, WithBlockReclaim(0), WithBitmapRecycling(16),
+		WithExcludedRanges(AddrRange{First: netip.MustParseAddr("2001:db8::"), Last: netip.MustParseAddr("2001:db8::1")}))
+	if err != nil {
+		t.Fatalf("NewPool error: %v", err)
+	}
+	addrs := fillBlocks(t, pool, 30)
+	first := &pool.blocks[0].used[0]
+
+	// Both blocks empty, only the first fits in the cap
+	for _, addr := range addrs {
+		if errRelease := pool.ReleaseAddr(addr); errRelease != nil {
+			t.Fatalf("ReleaseAddr error: %v", errRelease)
+		}
+	}
+	if st := pool.Stats(); st.Blocks != 0 || st.SpareBytes != 16 {
+		t.Fatalf("Stats() = %+v; want no block and 16 spare bytes", st)
+	}
+	if pool.spare[0].used[0] != 0 {
+		t.Fatalf("recycled bitmap = %#x; want excluded bits cleared", pool.spare[0].used[0])
+	}
+
+	// Block 0 is created again from the recycled bitmap, with its exclusions marked again
+	addr, err := pool.AllocateAddr()
+	if err != nil {
+		t.Fatalf("AllocateAddr error: %v", err)
+	}
+	if addr != netip.MustParseAddr("2001:db8::2") {
+		t.Errorf("AllocateAddr = %s; want 2001:db8::2", addr)
+	}
+	if &pool.blocks[0].used[0] != first {
+		t.Errorf("block 0 does not reuse the recycled bitmap")
+	}
+	if st := pool.Stats(); st.SpareBytes != 0 || st.Allocated != 1 || st.Free != 13 {
+		t.Errorf("Stats() = %+v; want 1 allocated, 13 free and nothing spare", st)
+	}
+}
@@ -0,0 +1,351 @@
+package cidrx
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"math/bits"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// ShardedPool spreads allocations over several independently locked Pools to remove the contention of a single
+// mutex. The block index space of the network is split into equal contiguous ranges, one per shard, so every shard
+// is an ordinary Pool over a sub-network. Allocate, Release and Snapshot behave as on a single Pool: Allocate only
+// fails once every shard is exhausted, and Snapshot returns the state of the whole network.
+type ShardedPool struct {
+	shards []*Pool
+	// number of blocks per shard, shard i holds global block indices [i*shardBlocks, (i+1)*shardBlocks)
+	shardBlocks uint64
+
+	// configuration of the whole network, used to route Release and to merge snapshots
+	blockMask   net.IPMask
+	networkAddr Uint128
+	hostBits    uint
+	blockSize   uint64
+	maxBlocks   uint64
+	ipv4        bool
+
+	// affinity hands out shard indices per P (logical processor), so goroutines running on the same P keep hitting
+	// the same shard when no hint is given
+	affinity sync.Pool
+	// next shard index assigned to a P that has none yet
+	nextShard atomic.Uint64
+}
+
+// NewShardedPool constructs a ShardedPool over the given IPv6 or IPv4 network. The arguments match NewPool, plus the
+// number of shards, which must be a power of two no larger than the number of blocks. expectedBlocks is spread over
+// the shards and opts are applied to every shard, except that excluded ranges, such as those of WithReservedAnycast,
+// are worked out over the whole network. WithAllocationStrategy is rejected with more than one shard, since a strategy
+// can't be shared between pools.
+func NewShardedPool(netAddress string, netPrefixLen, blockPrefix, expectedBlocks, shards int,
+	opts ...Option) (*ShardedPool, error) {
+	// Validate the whole network first, its configuration is then split over the shards
+	whole, err := NewPool(netAddress, netPrefixLen, blockPrefix, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	sp, err := newShardedPool(whole.Snapshot(), shards)
+	if err != nil {
+		return nil, err
+	}
+	excluded, err := sp.optionExclusions(opts)
+	if err != nil {
+		return nil, err
+	}
+	for i := range sp.shards {
+		sp.shards[i], err = initPool(sp.shardAddr(i).toIP(), sp.shardPrefixLen(), ipv6BitLen-int(sp.hostBits),
+			expectedBlocks/shards, sp.ipv4, sp.shardOptions(i, opts, excluded))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return sp, nil
+}
+
+// NewShardedPoolFromSnapshot rebuilds a ShardedPool with the given number of shards from a Snapshot, which may come
+// from either a Pool or a ShardedPool of the same network. Excluded ranges are clipped to each shard, and leases are
+// kept by the shard holding their address and released by ReapExpired once they expire. As with NewPoolFromSnapshot,
+// opts must be passed again, and they are applied to the shards as by NewShardedPool.
+func NewShardedPoolFromSnapshot(s *Snapshot, shards int, opts ...Option) (*ShardedPool, error) {
+	if s.BlockMask == nil || s.BlockSize == 0 {
+		return nil, fmt.Errorf("%w: incomplete configuration", ErrSnapshotCorrupt)
+	}
+
+	sp, err := newShardedPool(s, shards)
+	if err != nil {
+		return nil, err
+	}
+	excluded, err := sp.optionExclusions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Split blocks, free list, exclusions and leases by owning shard, translating global block indices into local ones
+	parts := make([]*Snapshot, shards)
+	for i := range parts {
+		parts[i] = &Snapshot{
+			BlockMask:   net.CIDRMask(sp.shardPrefixLen(), ipv6BitLen),
+			NetworkAddr: sp.shardAddr(i),
+			HostBits:    sp.hostBits,
+			BlockSize:   sp.blockSize,
+			MaxBlocks:   sp.shardBlocks,
+			IPv4:        sp.ipv4,
+			Blocks:      make(map[uint64][]uint64),
+			NextLeaseID: s.NextLeaseID,
+			Excluded:    sp.clipExcluded(i, s.Excluded),
+		}
+	}
+	for bi, words := range s.Blocks {
+		part := parts[bi/sp.shardBlocks]
+		part.Blocks[bi%sp.shardBlocks] = words
+	}
+	for _, bi := range s.FreeList {
+		part := parts[bi/sp.shardBlocks]
+		part.FreeList = append(part.FreeList, bi%sp.shardBlocks)
+	}
+	// Lease IDs stay unique across shards since a ShardedPool never hands out new leases
+	for _, l := range s.Leases {
+		bi, ok := sp.blockOf(fromAddr(l.Addr))
+		if !ok {
+			return nil, fmt.Errorf("%w: lease %d on %s outside the network", ErrSnapshotCorrupt, l.ID, l.Addr)
+		}
+		part := parts[bi/sp.shardBlocks]
+		part.Leases = append(part.Leases, l)
+	}
+
+	for i, part := range parts {
+		// NewPoolFromSnapshot moves past existing blocks, so starting from 0 resumes at the first block never created
+		if sp.shards[i], err = NewPoolFromSnapshot(part, sp.shardOptions(i, opts, excluded)...); err != nil {
+			return nil, sp.globalError(i, err)
+		}
+	}
+	return sp, nil
+}
+
+// optionExclusions returns the ranges opts exclude from the whole network. Exclusions such as WithReservedAnycast
+// depend on the network they are applied to, so they are worked out once here and then clipped to each shard. It
+// also rejects allocation strategies, whose state can't be shared by several shards.
+func (sp *ShardedPool) optionExclusions(opts []Option) ([]AddrRange, error) {
+	p := &Pool{
+		blockMask:   sp.blockMask,
+		networkAddr: sp.networkAddr,
+		hostBits:    sp.hostBits,
+		blockSize:   sp.blockSize,
+		maxBlocks:   sp.maxBlocks,
+		ipv4:        sp.ipv4,
+	}
+	if err := p.applyOptions(opts); err != nil {
+		return nil, err
+	}
+	if p.strategy != nil && len(sp.shards) > 1 {
+		return nil, fmt.Errorf("%w: an allocation strategy can't be shared by %d shards", ErrInvalidConfig,
+			len(sp.shards))
+	}
+	p.applyExclusions()
+	return p.excludedRanges(), nil
+}
+
+// shardOptions returns the options of shard i: opts, except that the ranges they exclude are replaced by the part of
+// excluded, as returned by optionExclusions, that falls in the shard
+func (sp *ShardedPool) shardOptions(i int, opts []Option, excluded []AddrRange) []Option {
+	clipped := sp.clipExcluded(i, excluded)
+	return append(slices.Clip(opts), func(p *Pool) {
+		p.excluded = nil
+		WithExcludedRanges(clipped...)(p)
+	})
+}
+
+// clipExcluded returns the parts of the excluded ranges that fall in shard i
+func (sp *ShardedPool) clipExcluded(i int, excluded []AddrRange) []AddrRange {
+	first := sp.shardAddr(i)
+	last := first.add(hostMask(sp.hostBits + uint(bits.TrailingZeros64(sp.shardBlocks))))
+
+	var clipped []AddrRange
+	for _, r := range excluded {
+		f, l := fromAddr(r.First), fromAddr(r.Last)
+		if l.cmp(first) < 0 || f.cmp(last) > 0 {
+			continue
+		}
+		if f.cmp(first) < 0 {
+			r.First = sp.outAddr(first)
+		}
+		if l.cmp(last) > 0 {
+			r.Last = sp.outAddr(last)
+		}
+		clipped = append(clipped, r)
+	}
+	return clipped
+}
+
+// outAddr returns u in the form callers use, unmapped for IPv4 networks
+func (sp *ShardedPool) outAddr(u Uint128) netip.Addr {
+	if sp.ipv4 {
+		return u.toAddr().Unmap()
+	}
+	return u.toAddr()
+}
+
+// newShardedPool copies the network configuration held by cfg, shards are filled in by the caller
+func newShardedPool(cfg *Snapshot, shards int) (*ShardedPool, error) {
+	if shards <= 0 || shards&(shards-1) != 0 || uint64(shards) > cfg.MaxBlocks {
+		return nil, fmt.Errorf("%w: shards must be a power of two between 1 and %d, got %d", ErrInvalidConfig,
+			cfg.MaxBlocks, shards)
+	}
+
+	sp := &ShardedPool{
+		shards:      make([]*Pool, shards),
+		shardBlocks: cfg.MaxBlocks / uint64(shards),
+		blockMask:   append(net.IPMask{}, cfg.BlockMask...),
+		networkAddr: cfg.NetworkAddr,
+		hostBits:    cfg.HostBits,
+		blockSize:   cfg.BlockSize,
+		maxBlocks:   cfg.MaxBlocks,
+		ipv4:        cfg.IPv4,
+	}
+	sp.affinity.New = func() any {
+		shard := int(sp.nextShard.Add(1) % uint64(shards))
+		return &shard
+	}
+	return sp, nil
+}
+
+// shardAddr returns the first address of shard i
+func (sp *ShardedPool) shardAddr(i int) Uint128 {
+	return sp.networkAddr.add(Uint128{Lo: uint64(i) * sp.shardBlocks}.lsh(sp.hostBits))
+}
+
+// shardPrefixLen returns the prefix length of the sub-network covered by each shard
+func (sp *ShardedPool) shardPrefixLen() int {
+	ones, _ := sp.blockMask.Size()
+	return ones + bits.TrailingZeros(uint(len(sp.shards)))
+}
+
+// Shards returns the number of shards.
+func (sp *ShardedPool) Shards() int {
+	return len(sp.shards)
+}
+
+// Allocate returns a free IP from the pool. Goroutines running on the same logical processor prefer the same shard,
+// falling back to the others when it is exhausted.
+func (sp *ShardedPool) Allocate() (net.IP, error) {
+	shard, _ := sp.affinity.Get().(*int)
+	defer sp.affinity.Put(shard)
+
+	return sp.allocateFrom(*shard)
+}
+
+// AllocateHint returns a free IP, preferring the shard selected by hint (hint modulo the number of shards). Callers
+// can pass a stable key, such as a tenant or node ID, to keep related allocations in one shard.
+func (sp *ShardedPool) AllocateHint(hint uint64) (net.IP, error) {
+	return sp.allocateFrom(int(hint % uint64(len(sp.shards))))
+}
+
+// allocateFrom tries the shards in order starting at start, reporting ErrPoolExhausted only if all of them are
+func (sp *ShardedPool) allocateFrom(start int) (net.IP, error) {
+	for i := range sp.shards {
+		ip, err := sp.shards[(start+i)%len(sp.shards)].Allocate()
+		if !errors.Is(err, ErrPoolExhausted) {
+			return ip, err
+		}
+	}
+	return nil, ErrPoolExhausted
+}
+
+// Release frees an IP back to the shard that owns it.
+func (sp *ShardedPool) Release(ip net.IP) error {
+	if ip.To16() == nil || (ip.To4() != nil) != sp.ipv4 {
+		return notInPool("release", ip)
+	}
+
+	bi, ok := sp.blockOf(fromIP(ip))
+	if !ok {
+		return notInPool("release", ip)
+	}
+
+	shard := int(bi / sp.shardBlocks)
+	return sp.globalError(shard, sp.shards[shard].Release(ip))
+}
+
+// ReapExpired releases the expired leases of every shard, see Pool.ReapExpired, and returns how many were released.
+// It stops at the first shard that fails.
+func (sp *ShardedPool) ReapExpired() (int, error) {
+	reaped := 0
+	for i, shard := range sp.shards {
+		n, err := shard.ReapExpired()
+		reaped += n
+		if err != nil {
+			return reaped, sp.globalError(i, err)
+		}
+	}
+	return reaped, nil
+}
+
+// blockOf returns the index within the whole network of the block u falls in, or false if u lies outside of it
+func (sp *ShardedPool) blockOf(u Uint128) (uint64, bool) {
+	if u.cmp(sp.networkAddr) < 0 {
+		return 0, false
+	}
+	bi := u.sub(sp.networkAddr).rsh(sp.hostBits)
+	if bi.Hi != 0 || bi.Lo >= sp.maxBlocks {
+		return 0, false
+	}
+	return bi.Lo, true
+}
+
+// globalError turns the block index of an AddressError reported by a shard back into the index within the whole
+// network
+func (sp *ShardedPool) globalError(shard int, err error) error {
+	var addrErr *AddressError
+	if errors.As(err, &addrErr) {
+		addrErr.Block += uint64(shard) * sp.shardBlocks
+	}
+	return err
+}
+
+// Snapshot returns the state of the whole network as a single Snapshot, as if it was taken from one Pool. Shards are
+// locked one at a time, so the snapshot is only consistent per shard if allocations run concurrently.
+func (sp *ShardedPool) Snapshot() *Snapshot {
+	merged := &Snapshot{
+		BlockMask:      append(net.IPMask{}, sp.blockMask...),
+		NetworkAddr:    sp.networkAddr,
+		HostBits:       sp.hostBits,
+		BlockSize:      sp.blockSize,
+		NextBlockIndex: sp.maxBlocks,
+		MaxBlocks:      sp.maxBlocks,
+		IPv4:           sp.ipv4,
+		Blocks:         make(map[uint64][]uint64),
+	}
+
+	for i, shard := range sp.shards {
+		part := shard.Snapshot()
+		offset := uint64(i) * sp.shardBlocks
+
+		for bi, words := range part.Blocks {
+			merged.Blocks[offset+bi] = words
+		}
+		for _, bi := range part.FreeList {
+			merged.FreeList = append(merged.FreeList, offset+bi)
+		}
+		merged.Leases = append(merged.Leases, part.Leases...)
+		// Shards are in address order, so only a range cut at a shard boundary can touch the previous one
+		for _, r := range part.Excluded {
+			if n := len(merged.Excluded); n > 0 && merged.Excluded[n-1].Last.Next() == r.First {
+				merged.Excluded[n-1].Last = r.Last
+				continue
+			}
+			merged.Excluded = append(merged.Excluded, r)
+		}
+		merged.NextLeaseID = max(merged.NextLeaseID, part.NextLeaseID)
+		// The merged pool continues at the lowest block no shard has created yet
+		if part.NextBlockIndex < part.MaxBlocks {
+			merged.NextBlockIndex = min(merged.NextBlockIndex, offset+part.NextBlockIndex)
+		}
+	}
+	slices.SortFunc(merged.Leases, func(a, b Lease) int { return cmp.Compare(a.ID, b.ID) })
+	return merged
+}
@@ -0,0 +1,321 @@
+package cidrx //nolint:testpackage // it's OK to be just cidrx
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestNewShardedPoolInvalidShards ensures the shard count must be a power of two that fits the block count
+func TestNewShardedPoolInvalidShards(t *testing.T) {
+	// /64 with /120 blocks => 2^56 blocks, /126 with /128 blocks => 4 blocks
+	for _, tc := range []struct {
+		prefix, blockPrefix, shards int
+	}{
+		{64, 120, 0},
+		{64, 120, 3},
+		{126, 128, 8},
+	} {
+		if _, err := NewShardedPool("2001:db8::", tc.prefix, tc.blockPrefix, 0, tc.shards); err == nil {
+			t.Errorf("NewShardedPool(/%d, /%d, %d shards) expected error, got nil", tc.prefix, tc.blockPrefix, tc.shards)
+		}
+	}
+}
+
+// TestShardedPoolExhaustion ensures Allocate falls back to other shards and only fails once all are exhausted
+func TestShardedPoolExhaustion(t *testing.T) {
+	// /126 with /128 blocks => 4 addresses, one block each, 2 per shard
+	sp, err := NewShardedPool("2001:db8::", 126, 128, 4, 2)
+	if err != nil {
+		t.Fatalf("NewShardedPool error: %v", err)
+	}
+
+	seen := make(map[string]struct{})
+	for i := 0; i < 4; i++ {
+		// Always prefer shard 0, the last two allocations must spill into shard 1
+		ip, errAllocate := sp.AllocateHint(0)
+		if errAllocate != nil {
+			t.Fatalf("Allocate %d error: %v", i, errAllocate)
+		}
+		seen[ip.String()] = struct{}{}
+	}
+	if len(seen) != 4 {
+		t.Errorf("allocated %d distinct IPs; want 4", len(seen))
+	}
+	if _, errAllocate := sp.Allocate(); !errors.Is(errAllocate, ErrPoolExhausted) {
+		t.Errorf("Allocate on full pool err = %v; want ErrPoolExhausted", errAllocate)
+	}
+}
+
+// TestShardedPoolHint ensures a hint selects the shard covering the matching part of the network
+func TestShardedPoolHint(t *testing.T) {
+	// 4 shards over a /64 => each shard is a /66
+	sp, err := NewShardedPool("2001:db8::", 64, 120, 0, 4)
+	if err != nil {
+		t.Fatalf("NewShardedPool error: %v", err)
+	}
+
+	for hint, want := range []string{"2001:db8::", "2001:db8:0:0:4000::", "2001:db8:0:0:8000::", "2001:db8:0:0:c000::"} {
+		ip, errAllocate := sp.AllocateHint(uint64(hint))
+		if errAllocate != nil {
+			t.Fatalf("AllocateHint(%d) error: %v", hint, errAllocate)
+		}
+		if !ip.Equal(net.ParseIP(want)) {
+			t.Errorf("AllocateHint(%d) = %v; want %s", hint, ip, want)
+		}
+	}
+}
+
+// TestShardedPoolRelease ensures Release routes to the owning shard and rejects foreign addresses
+func TestShardedPoolRelease(t *testing.T) {
+	sp, err := NewShardedPool("2001:db8::", 64, 120, 0, 4)
+	if err != nil {
+		t.Fatalf("NewShardedPool error: %v", err)
+	}
+
+	ip, _ := sp.AllocateHint(2)
+	if errRelease := sp.Release(ip); errRelease != nil {
+		t.Fatalf("Release error: %v", errRelease)
+	}
+	if errRelease := sp.Release(ip); errRelease == nil {
+		t.Error("expected error releasing twice, got nil")
+	}
+	if errRelease := sp.Release(net.ParseIP("2001:db9::1")); errRelease == nil {
+		t.Error("expected error releasing IP outside the network, got nil")
+	}
+	if errRelease := sp.Release(net.ParseIP("192.0.2.1")); errRelease == nil {
+		t.Error("expected error releasing IPv4, got nil")
+	}
+}
+
+// TestShardedPoolSnapshot ensures the merged snapshot restores the same state into a Pool or a ShardedPool
+func TestShardedPoolSnapshot(t *testing.T) {
+	sp, err := NewShardedPool("2001:db8::", 64, 124, 0, 4)
+	if err != nil {
+		t.Fatalf("NewShardedPool error: %v", err)
+	}
+
+	var held []net.IP
+	for hint := uint64(0); hint < 4; hint++ {
+		for i := 0; i < 20; i++ {
+			ip, errAllocate := sp.AllocateHint(hint)
+			if errAllocate != nil {
+				t.Fatalf("AllocateHint error: %v", errAllocate)
+			}
+			held = append(held, ip)
+		}
+	}
+	// Leave a hole in the first block of shard 2
+	if errRelease := sp.Release(held[45]); errRelease != nil {
+		t.Fatalf("Release error: %v", errRelease)
+	}
+	held = append(held[:45], held[46:]...)
+
+	snap := sp.Snapshot()
+	pool, err := NewPoolFromSnapshot(snap)
+	if err != nil {
+		t.Fatalf("NewPoolFromSnapshot error: %v", err)
+	}
+	resharded, err := NewShardedPoolFromSnapshot(snap, 2)
+	if err != nil {
+		t.Fatalf("NewShardedPoolFromSnapshot error: %v", err)
+	}
+
+	// Neither restored pool hands out an address that is still held
+	isHeld := make(map[string]struct{}, len(held))
+	for _, ip := range held {
+		isHeld[ip.String()] = struct{}{}
+	}
+	for i := 0; i < 100; i++ {
+		ip, errAllocate := pool.Allocate()
+		if errAllocate != nil {
+			t.Fatalf("Pool Allocate error: %v", errAllocate)
+		}
+		if _, dup := isHeld[ip.String()]; dup {
+			t.Fatalf("Pool restored from sharded snapshot handed out held IP %v", ip)
+		}
+		ip, errAllocate = resharded.Allocate()
+		if errAllocate != nil {
+			t.Fatalf("ShardedPool Allocate error: %v", errAllocate)
+		}
+		if _, dup := isHeld[ip.String()]; dup {
+			t.Fatalf("ShardedPool restored from snapshot handed out held IP %v", ip)
+		}
+	}
+
+	// Held addresses are still allocated in the resharded pool
+	for _, ip := range held {
+		if errRelease := resharded.Release(ip); errRelease != nil {
+			t.Errorf("Release %v after resharding: %v", ip, errRelease)
+		}
+	}
+}
+
+// TestShardedPoolLeases ensures leases survive a restore into shards, come back in the merged snapshot and are reaped
+// once expired
+func TestShardedPoolLeases(t *testing.T) {
+	clock := &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	pool, _ := NewPool("2001:db8::", 64, 124, 4, WithClock(clock.now))
+	held, _, err := pool.AllocateLease(time.Hour)
+	if err != nil {
+		t.Fatalf("AllocateLease error: %v", err)
+	}
+	if _, _, err = pool.AllocateLease(time.Hour); err != nil {
+		t.Fatalf("AllocateLease error: %v", err)
+	}
+	if err = pool.Release(held); err != nil {
+		t.Fatalf("Release error: %v", err)
+	}
+	snap := pool.Snapshot()
+
+	sp, err := NewShardedPoolFromSnapshot(snap, 4, WithClock(clock.now))
+	if err != nil {
+		t.Fatalf("NewShardedPoolFromSnapshot error: %v", err)
+	}
+	merged := sp.Snapshot()
+	if !slices.Equal(merged.Leases, snap.Leases) || merged.NextLeaseID != snap.NextLeaseID {
+		t.Errorf("merged leases = %v, next %d; want %v, next %d", merged.Leases, merged.NextLeaseID, snap.Leases,
+			snap.NextLeaseID)
+	}
+
+	if n, errReap := sp.ReapExpired(); n != 0 || errReap != nil {
+		t.Errorf("ReapExpired before expiry = %d, %v; want 0, nil", n, errReap)
+	}
+	clock.advance(time.Hour)
+	if n, errReap := sp.ReapExpired(); n != 1 || errReap != nil {
+		t.Errorf("ReapExpired = %d, %v; want 1, nil", n, errReap)
+	}
+	if leases := sp.Snapshot().Leases; len(leases) != 0 {
+		t.Errorf("leases after ReapExpired = %v; want none", leases)
+	}
+	if errRelease := sp.Release(snap.Leases[0].Addr.AsSlice()); !errors.Is(errRelease, ErrNotAllocated) {
+		t.Errorf("Release of a reaped address err = %v; want ErrNotAllocated", errRelease)
+	}
+}
+
+// TestShardedPoolExclusions ensures excluded ranges survive a restore into shards, including ranges that cross a shard
+// boundary and blocks created after the restore
+func TestShardedPoolExclusions(t *testing.T) {
+	// /120 with /124 blocks => 16 blocks, 4 per shard. ::38-::47 crosses into the second shard and the anycast range
+	// ::80-::ff covers the last two shards.
+	pool, err := NewPool("2001:db8::", 120, 124, 1, WithReservedAnycast(), WithExcludedRanges(AddrRange{
+		First: netip.MustParseAddr("2001:db8::38"), Last: netip.MustParseAddr("2001:db8::47"),
+	}))
+	if err != nil {
+		t.Fatalf("NewPool error: %v", err)
+	}
+	if _, err = pool.Allocate(); err != nil {
+		t.Fatalf("Allocate error: %v", err)
+	}
+	snap := pool.Snapshot()
+
+	sp, err := NewShardedPoolFromSnapshot(snap, 4)
+	if err != nil {
+		t.Fatalf("NewShardedPoolFromSnapshot error: %v", err)
+	}
+	if merged := sp.Snapshot().Excluded; !slices.Equal(merged, snap.Excluded) {
+		t.Errorf("merged Excluded = %v; want %v", merged, snap.Excluded)
+	}
+
+	// 256 addresses, minus the one allocated, ::0, ::38-::47 and ::80-::ff
+	for i := 0; i < 110; i++ {
+		ip, errAllocate := sp.Allocate()
+		if errAllocate != nil {
+			t.Fatalf("Allocate #%d error: %v", i, errAllocate)
+		}
+		if addr, _ := netip.AddrFromSlice(ip); pool.isExcluded(fromAddr(addr)) {
+			t.Fatalf("Allocate handed out excluded address %v", ip)
+		}
+	}
+	if _, err = sp.Allocate(); !errors.Is(err, ErrPoolExhausted) {
+		t.Errorf("Allocate on a full pool err = %v; want ErrPoolExhausted", err)
+	}
+	if err = sp.Release(net.ParseIP("2001:db8::40")); !errors.Is(err, ErrExcluded) {
+		t.Errorf("Release of an excluded address err = %v; want ErrExcluded", err)
+	}
+}
+
+// TestShardedPoolOptions ensures options reach every shard, with anycast and excluded ranges worked out over the whole
+// network, and that a strategy or an exclusion over a restored allocation is refused
+func TestShardedPoolOptions(t *testing.T) {
+	// /120 with /124 blocks => 16 blocks, 4 per shard. Anycast is ::0 and ::80-::ff of the whole network only.
+	gap := AddrRange{First: netip.MustParseAddr("2001:db8::38"), Last: netip.MustParseAddr("2001:db8::47")}
+	sp, err := NewShardedPool("2001:db8::", 120, 124, 1, 4, WithReservedAnycast(), WithExcludedRanges(gap))
+	if err != nil {
+		t.Fatalf("NewShardedPool error: %v", err)
+	}
+	want := []AddrRange{
+		{First: netip.MustParseAddr("2001:db8::"), Last: netip.MustParseAddr("2001:db8::")},
+		gap,
+		{First: netip.MustParseAddr("2001:db8::80"), Last: netip.MustParseAddr("2001:db8::ff")},
+	}
+	if got := sp.Snapshot().Excluded; !slices.Equal(got, want) {
+		t.Errorf("Excluded = %v; want %v", got, want)
+	}
+
+	// 256 addresses, minus ::0, ::38-::47 and ::80-::ff
+	for i := 0; i < 111; i++ {
+		if _, errAllocate := sp.Allocate(); errAllocate != nil {
+			t.Fatalf("Allocate #%d error: %v", i, errAllocate)
+		}
+	}
+	if _, err = sp.Allocate(); !errors.Is(err, ErrPoolExhausted) {
+		t.Errorf("Allocate on a full pool err = %v; want ErrPoolExhausted", err)
+	}
+
+	// The restored shards keep the exclusions and can't exclude allocated addresses on top
+	snap := sp.Snapshot()
+	if _, err = NewShardedPoolFromSnapshot(snap, 2, WithReservedAnycast()); err != nil {
+		t.Errorf("NewShardedPoolFromSnapshot with the same exclusions error: %v", err)
+	}
+	taken := netip.MustParsePrefix("2001:db8::50/124")
+	var addrErr *AddressError
+	_, err = NewShardedPoolFromSnapshot(snap, 2, WithExcludedPrefixes(taken))
+	if !errors.As(err, &addrErr) || !errors.Is(err, ErrAlreadyAllocated) || addrErr.Block != 5 {
+		t.Errorf("NewShardedPoolFromSnapshot excluding %s err = %v; want ErrAlreadyAllocated in block 5", taken, err)
+	}
+
+	_, err = NewShardedPool("2001:db8::", 120, 124, 1, 4, WithAllocationStrategy(FirstFit()))
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Errorf("NewShardedPool with a strategy err = %v; want ErrInvalidConfig", err)
+	}
+}
+
+// TestShardedPoolConcurrentAllocate ensures concurrent allocations never hand out the same IP twice
+func TestShardedPoolConcurrentAllocate(t *testing.T) {
+	sp, err := NewShardedPool("2001:db8::", 64, 120, 16, 8)
+	if err != nil {
+		t.Fatalf("NewShardedPool error: %v", err)
+	}
+
+	const goroutines, perGoroutine = 16, 500
+	var (
+		mu   sync.Mutex
+		seen = make(map[string]struct{}, goroutines*perGoroutine)
+		wg   sync.WaitGroup
+	)
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < perGoroutine; i++ {
+				ip, errAllocate := sp.Allocate()
+				if errAllocate != nil {
+					t.Error(errAllocate)
+					return
+				}
+				mu.Lock()
+				if _, dup := seen[ip.String()]; dup {
+					t.Errorf("IP %v allocated twice", ip)
+				}
+				seen[ip.String()] = struct{}{}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -2,12 +2,14 @@ package cidrx
 
 import (
 	"fmt"
-	"math/bits"
+	"maps"
 	"net"
+	"slices"
 )
 
-// Snapshot captures the current state of a Pool for export/import (no serialization).
-// The Blocks map holds raw bitmap words for each active block.
+// Snapshot captures the current state of a Pool for export/import. It implements encoding.BinaryMarshaler and
+// encoding.BinaryUnmarshaler, so it can be persisted and later restored via NewPoolFromSnapshot.
+// The Blocks map holds raw bitmap words for each block with at least one allocated address.
 type Snapshot struct {
 	BlockMask      net.IPMask // the mask for each block
 	NetworkAddr    Uint128    // base network address
@@ -15,17 +17,25 @@ type Snapshot struct {
 	BlockSize      uint64     // addresses per block
 	NextBlockIndex uint64     // next unused block index
 	MaxBlocks      uint64     // total possible blocks
+	IPv4           bool       // IPv4 network, kept in the IPv4-mapped range
 
 	FreeList []uint64            // block indices with free addresses
 	Blocks   map[uint64][]uint64 // blockIndex -> bitmap words
+
+	Leases      []Lease // active leases, ordered by ID
+	NextLeaseID LeaseID // last lease ID handed out
+
+	Excluded []AddrRange // excluded address ranges, sorted and merged
 }
 
 // NewPoolFromSnapshot constructs a Pool from a previously taken Snapshot. It discards any existing state and recreates
-// blocks, freeList, and indexes.
-func NewPoolFromSnapshot(s *Snapshot) (*Pool, error) {
+// blocks, freeList, leases and indexes. Options are not part of the snapshot and must be passed again, except for the
+// excluded ranges: those of the snapshot are kept and merged with any passed as options. Excluding an address the
+// snapshot holds as allocated fails with an *AddressError wrapping ErrAlreadyAllocated.
+func NewPoolFromSnapshot(s *Snapshot, opts ...Option) (*Pool, error) {
 	// Validate snapshot consistency
 	if s.BlockMask == nil || s.BlockSize == 0 {
-		return nil, fmt.Errorf("invalid snapshot: incomplete configuration")
+		return nil, fmt.Errorf("%w: incomplete configuration", ErrSnapshotCorrupt)
 	}
 
 	// Initialize pool structure
@@ -35,11 +45,24 @@ func NewPoolFromSnapshot(s *Snapshot) (*Pool, error) {
 		hostBits:       s.HostBits,
 		blockSize:      s.BlockSize,
 		blocks:         make(map[uint64]*block, len(s.Blocks)),
-		freeList:       make([]uint64, len(s.FreeList)),
+		freeList:       make([]uint64, 0, len(s.FreeList)),
 		nextBlockIndex: s.NextBlockIndex,
 		maxBlocks:      s.MaxBlocks,
+		ipv4:           s.IPv4,
+		nextLeaseID:    s.NextLeaseID,
+	}
+	if err := p.applyOptions(opts); err != nil {
+		return nil, err
+	}
+	added := len(p.excluded)
+	for _, r := range s.Excluded {
+		if p.exclude(r.First, r.Last, fmt.Sprintf("%s-%s", r.First, r.Last)); p.optErr != nil {
+			return nil, fmt.Errorf("%w: %w", ErrSnapshotCorrupt, p.optErr)
+		}
+	}
+	if err := p.checkExcludedFree(s.Blocks, p.excluded[:added], p.excluded[added:]); err != nil {
+		return nil, err
 	}
-	copy(p.freeList, s.FreeList)
 
 	// Rebuild each block
 	for idx, words := range s.Blocks {
@@ -49,45 +72,88 @@ func NewPoolFromSnapshot(s *Snapshot) (*Pool, error) {
 
 		// Recreate block
 		blk := newBlock(prefix, p.blockSize)
-		copy(blk.used, words)
-
-		// Recalc freeCount
-		var usedCount uint64
-		for _, w := range words {
-			usedCount += uint64(bits.OnesCount64(w))
+		if err := blk.load(words); err != nil {
+			return nil, fmt.Errorf("%w: block %d: %w", ErrSnapshotCorrupt, idx, err)
 		}
-		blk.freeCount = p.blockSize - usedCount
 		p.blocks[idx] = blk
 	}
+	// Marks the excluded ranges and never lets Allocate recreate a block the snapshot already holds
+	p.applyExclusions()
+
+	// List the blocks with free addresses in the snapshot order, then any the snapshot missed. Duplicates and full
+	// blocks, which older versions could list, are dropped.
+	for _, idx := range s.FreeList {
+		if blk, ok := p.blocks[idx]; ok {
+			p.relist(idx, blk)
+		}
+	}
+	for _, idx := range slices.Sorted(maps.Keys(p.blocks)) {
+		p.relist(idx, p.blocks[idx])
+	}
+
+	// Expired leases are kept as they are, the reaper releases them on its next run
+	for _, l := range s.Leases {
+		p.addLease(l)
+	}
 
 	return p, nil
 }
 
 // Snapshot creates a deep copy of the Pool's current state.
 func (p *Pool) Snapshot() *Snapshot {
-	p.mu.Lock()
-	defer p.mu.Unlock()
+	p.mu.RLock()
+	defer p.mu.RUnlock()
 
-	// Copy freeList
-	fl := make([]uint64, len(p.freeList))
-	copy(fl, p.freeList)
+	return p.snapshotLocked()
+}
 
-	// Copy each block's bitmap words
+// snapshotLocked builds the Snapshot, the caller must hold p.mu
+func (p *Pool) snapshotLocked() *Snapshot {
+	// Copy each block's bitmap words. Empty blocks are left out, a restored pool creates them again when needed, so
+	// NextBlockIndex is moved back to the lowest block that is missing below it.
+	next := p.nextBlockIndex
 	bm := make(map[uint64][]uint64, len(p.blocks))
 	for idx, blk := range p.blocks {
+		if blk.empty() {
+			next = min(next, idx)
+			continue
+		}
 		words := make([]uint64, len(blk.used))
 		copy(words, blk.used)
 		bm[idx] = words
 	}
+	for _, idx := range p.reclaimed {
+		if _, exists := p.blocks[idx]; !exists {
+			next = min(next, idx)
+		}
+	}
+
+	// Copy freeList, leaving out the blocks omitted above
+	fl := make([]uint64, 0, len(p.freeList))
+	for _, idx := range p.freeList {
+		if _, ok := bm[idx]; ok {
+			fl = append(fl, idx)
+		}
+	}
+
+	// Copy leases in ID order so equal pools produce equal snapshots
+	var leases []Lease
+	for _, id := range slices.Sorted(maps.Keys(p.leases)) {
+		leases = append(leases, p.leases[id].Lease)
+	}
 
 	return &Snapshot{
 		BlockMask:      append(net.IPMask{}, p.blockMask...),
 		NetworkAddr:    p.networkAddr,
 		HostBits:       p.hostBits,
 		BlockSize:      p.blockSize,
-		NextBlockIndex: p.nextBlockIndex,
+		NextBlockIndex: next,
 		MaxBlocks:      p.maxBlocks,
+		IPv4:           p.ipv4,
 		FreeList:       fl,
 		Blocks:         bm,
+		Leases:         leases,
+		NextLeaseID:    p.nextLeaseID,
+		Excluded:       p.excludedRanges(),
 	}
 }
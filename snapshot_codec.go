@@ -0,0 +1,401 @@
+package cidrx
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"maps"
+	"net"
+	"slices"
+	"time"
+)
+
+// Binary layout of an encoded Snapshot (fixed-width integers are big-endian):
+//
+//	magic    [4]byte  "CDRX"
+//	version  uint16   format version
+//	length   uint64   number of payload bytes that follow
+//	payload  []field  sequence of tag-length-value fields
+//	checksum uint32   CRC-32 (IEEE) of everything above
+//
+// Each field is a uvarint tag, a uvarint length and that many bytes of value, so decoders can skip fields they do
+// not know about. Block bitmaps only store their non-zero words, which keeps sparsely used blocks small on disk.
+const (
+	snapshotMagic      = "CDRX"
+	snapshotVersion    = 1
+	snapshotHeaderLen  = len(snapshotMagic) + 2 + 8
+	snapshotTrailerLen = 4
+
+	// maxBlockWords bounds the bitmap of a decoded block to 2^32 addresses (512 MiB), the largest block an IPv4 pool
+	// can have. Larger blocks can't be backed by a bitmap in memory, so no Pool ever holds one.
+	maxBlockWords = 1 << 26
+)
+
+// Snapshot field tags. A tag is never reused once assigned.
+const (
+	tagBlockMask uint64 = iota + 1
+	tagNetworkAddr
+	tagHostBits
+	tagBlockSize
+	tagNextBlockIndex
+	tagMaxBlocks
+	tagFreeList
+	tagBlock
+	tagLease
+	tagNextLeaseID
+	tagIPv4
+	tagExcluded
+)
+
+// MarshalBinary implements encoding.BinaryMarshaler. The output is deterministic: blocks are written in index order.
+func (s *Snapshot) MarshalBinary() ([]byte, error) {
+	out := make([]byte, 0, snapshotHeaderLen+snapshotTrailerLen)
+	out = append(out, snapshotMagic...)
+	out = binary.BigEndian.AppendUint16(out, snapshotVersion)
+	// Payload length is patched in once all fields are written
+	out = binary.BigEndian.AppendUint64(out, 0)
+
+	out = appendField(out, tagBlockMask, s.BlockMask)
+	out = appendField(out, tagNetworkAddr, appendUint128(nil, s.NetworkAddr))
+	out = appendField(out, tagHostBits, binary.AppendUvarint(nil, uint64(s.HostBits)))
+	out = appendField(out, tagBlockSize, binary.AppendUvarint(nil, s.BlockSize))
+	out = appendField(out, tagNextBlockIndex, binary.AppendUvarint(nil, s.NextBlockIndex))
+	out = appendField(out, tagMaxBlocks, binary.AppendUvarint(nil, s.MaxBlocks))
+
+	fl := binary.AppendUvarint(nil, uint64(len(s.FreeList)))
+	for _, idx := range s.FreeList {
+		fl = binary.AppendUvarint(fl, idx)
+	}
+	out = appendField(out, tagFreeList, fl)
+
+	for _, idx := range slices.Sorted(maps.Keys(s.Blocks)) {
+		out = appendField(out, tagBlock, appendBlockWords(nil, idx, s.Blocks[idx]))
+	}
+
+	for _, l := range s.Leases {
+		out = appendField(out, tagLease, appendLease(nil, l))
+	}
+	if s.NextLeaseID != 0 {
+		out = appendField(out, tagNextLeaseID, binary.AppendUvarint(nil, uint64(s.NextLeaseID)))
+	}
+	if s.IPv4 {
+		out = appendField(out, tagIPv4, nil)
+	}
+	for _, r := range s.Excluded {
+		out = appendField(out, tagExcluded, appendUint128(appendUint128(nil, fromAddr(r.First)), fromAddr(r.Last)))
+	}
+
+	binary.BigEndian.PutUint64(out[snapshotHeaderLen-8:], uint64(len(out)-snapshotHeaderLen))
+	return binary.BigEndian.AppendUint32(out, crc32.ChecksumIEEE(out)), nil
+}
+
+// UnmarshalBinary implements encoding.BinaryUnmarshaler. It verifies the header, length and checksum before decoding
+// any field, and validates the decoded configuration so the result can be passed straight to NewPoolFromSnapshot.
+// On error the receiver is left untouched.
+func (s *Snapshot) UnmarshalBinary(data []byte) error {
+	if len(data) < snapshotHeaderLen {
+		return fmt.Errorf("%w: header needs %d bytes, got %d", ErrSnapshotTruncated, snapshotHeaderLen, len(data))
+	}
+	if string(data[:len(snapshotMagic)]) != snapshotMagic {
+		return fmt.Errorf("%w: bad magic %q", ErrSnapshotCorrupt, data[:len(snapshotMagic)])
+	}
+	if v := binary.BigEndian.Uint16(data[len(snapshotMagic):]); v != snapshotVersion {
+		return fmt.Errorf("%w: %d", ErrSnapshotVersion, v)
+	}
+
+	// Make sure the declared payload and the checksum fit in what we were given
+	length := binary.BigEndian.Uint64(data[snapshotHeaderLen-8:])
+	rest := uint64(len(data) - snapshotHeaderLen)
+	if rest < snapshotTrailerLen || length > rest-snapshotTrailerLen {
+		return fmt.Errorf("%w: payload declares %d bytes, %d available", ErrSnapshotTruncated, length, rest)
+	}
+	if length != rest-snapshotTrailerLen {
+		return fmt.Errorf("%w: %d trailing bytes", ErrSnapshotCorrupt, rest-snapshotTrailerLen-length)
+	}
+
+	end := snapshotHeaderLen + int(length)
+	if crc32.ChecksumIEEE(data[:end]) != binary.BigEndian.Uint32(data[end:]) {
+		return ErrSnapshotChecksum
+	}
+
+	decoded, err := decodeSnapshotFields(data[snapshotHeaderLen:end])
+	if err != nil {
+		return err
+	}
+	if errValidate := decoded.validate(); errValidate != nil {
+		return errValidate
+	}
+
+	*s = *decoded
+	return nil
+}
+
+// decodeSnapshotFields decodes the tag-length-value payload of an encoded snapshot
+func decodeSnapshotFields(payload []byte) (*Snapshot, error) {
+	s := &Snapshot{Blocks: make(map[uint64][]uint64)}
+	r := &snapshotReader{buf: payload}
+
+	for len(r.buf) > 0 && r.err == nil {
+		tag := r.uvarint()
+		val := &snapshotReader{buf: r.bytes(r.uvarint())}
+		if r.err != nil {
+			break
+		}
+
+		switch tag {
+		case tagBlockMask:
+			s.BlockMask = append(net.IPMask{}, val.bytes(uint64(len(val.buf)))...)
+		case tagNetworkAddr:
+			s.NetworkAddr = Uint128{Hi: val.fixed64(), Lo: val.fixed64()}
+		case tagHostBits:
+			s.HostBits = uint(val.uvarint())
+		case tagBlockSize:
+			s.BlockSize = val.uvarint()
+		case tagNextBlockIndex:
+			s.NextBlockIndex = val.uvarint()
+		case tagMaxBlocks:
+			s.MaxBlocks = val.uvarint()
+		case tagFreeList:
+			s.FreeList = val.uvarints()
+		case tagBlock:
+			idx, words := val.blockWords(s.BlockSize)
+			if _, dup := s.Blocks[idx]; dup && val.err == nil {
+				val.err = fmt.Errorf("%w: block %d encoded twice", ErrSnapshotCorrupt, idx)
+			}
+			s.Blocks[idx] = words
+		case tagLease:
+			s.Leases = append(s.Leases, val.lease())
+		case tagNextLeaseID:
+			s.NextLeaseID = LeaseID(val.uvarint())
+		case tagIPv4:
+			s.IPv4 = true
+		case tagExcluded:
+			first := Uint128{Hi: val.fixed64(), Lo: val.fixed64()}
+			last := Uint128{Hi: val.fixed64(), Lo: val.fixed64()}
+			s.Excluded = append(s.Excluded, AddrRange{First: first.toAddr(), Last: last.toAddr()})
+		default:
+			// Field written by a newer version, skip it
+			val.buf = nil
+		}
+
+		if val.err == nil && len(val.buf) != 0 {
+			val.err = fmt.Errorf("%w: %d unread bytes in field %d", ErrSnapshotCorrupt, len(val.buf), tag)
+		}
+		if val.err != nil {
+			return nil, val.err
+		}
+	}
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	return s, nil
+}
+
+// validate checks that the snapshot describes a pool NewPoolFromSnapshot can rebuild without panicking
+func (s *Snapshot) validate() error {
+	switch {
+	case len(s.BlockMask) != net.IPv6len:
+		return fmt.Errorf("%w: block mask has %d bytes", ErrSnapshotCorrupt, len(s.BlockMask))
+	case s.HostBits >= 64 || s.BlockSize != uint64(1)<<s.HostBits:
+		return fmt.Errorf("%w: block size %d does not match %d host bits", ErrSnapshotCorrupt, s.BlockSize, s.HostBits)
+	case s.MaxBlocks == 0 || s.NextBlockIndex > s.MaxBlocks:
+		return fmt.Errorf("%w: next block %d beyond %d blocks", ErrSnapshotCorrupt, s.NextBlockIndex, s.MaxBlocks)
+	case s.IPv4 && (s.NetworkAddr.Hi != 0 || s.NetworkAddr.Lo>>ipv4BitLen != 0xffff || s.HostBits > ipv4BitLen):
+		return fmt.Errorf("%w: IPv4 network %s outside the IPv4-mapped range", ErrSnapshotCorrupt, s.NetworkAddr.toAddr())
+	}
+
+	for _, idx := range s.FreeList {
+		if _, ok := s.Blocks[idx]; !ok {
+			return fmt.Errorf("%w: free list references missing block %d", ErrSnapshotCorrupt, idx)
+		}
+	}
+
+	words := int((s.BlockSize + 63) / 64)
+	for idx, w := range s.Blocks {
+		if idx >= s.MaxBlocks || len(w) != words {
+			return fmt.Errorf("%w: block %d has %d words, want %d", ErrSnapshotCorrupt, idx, len(w), words)
+		}
+		if tail := s.BlockSize % 64; tail != 0 && w[words-1]>>tail != 0 {
+			return fmt.Errorf("%w: block %d has bits set past its %d addresses", ErrSnapshotCorrupt, idx, s.BlockSize)
+		}
+	}
+
+	for _, r := range s.Excluded {
+		if r.First.Compare(r.Last) > 0 {
+			return fmt.Errorf("%w: excluded range %s-%s is reversed", ErrSnapshotCorrupt, r.First, r.Last)
+		}
+	}
+
+	// Every lease must point at an allocated address of an existing block, and each lease only once
+	seen := make(map[LeaseID]bool, len(s.Leases))
+	for _, l := range s.Leases {
+		if l.ID == 0 || l.ID > s.NextLeaseID || seen[l.ID] {
+			return fmt.Errorf("%w: lease %d invalid or duplicated", ErrSnapshotCorrupt, l.ID)
+		}
+		seen[l.ID] = true
+
+		u := fromAddr(l.Addr)
+		bi := u.sub(s.NetworkAddr).rsh(s.HostBits)
+		off := u.sub(s.NetworkAddr.add(bi.lsh(s.HostBits))).Lo
+		w, ok := s.Blocks[bi.Lo]
+		if u.cmp(s.NetworkAddr) < 0 || bi.Hi != 0 || !ok || w[off/64]&(1<<(off%64)) == 0 {
+			return fmt.Errorf("%w: lease %d on unallocated address %s", ErrSnapshotCorrupt, l.ID, l.Addr)
+		}
+	}
+	return nil
+}
+
+// appendField appends one tag-length-value field to buf
+func appendField(buf []byte, tag uint64, val []byte) []byte {
+	buf = binary.AppendUvarint(buf, tag)
+	buf = binary.AppendUvarint(buf, uint64(len(val)))
+	return append(buf, val...)
+}
+
+// appendUint128 appends x as two big-endian 64-bit words
+func appendUint128(buf []byte, x Uint128) []byte {
+	buf = binary.BigEndian.AppendUint64(buf, x.Hi)
+	return binary.BigEndian.AppendUint64(buf, x.Lo)
+}
+
+// appendBlockWords encodes a block as its index, word count and the list of non-zero words, each prefixed by the
+// gap since the previous non-zero word
+func appendBlockWords(buf []byte, idx uint64, words []uint64) []byte {
+	var nonZero uint64
+	for _, w := range words {
+		if w != 0 {
+			nonZero++
+		}
+	}
+
+	buf = binary.AppendUvarint(buf, idx)
+	buf = binary.AppendUvarint(buf, uint64(len(words)))
+	buf = binary.AppendUvarint(buf, nonZero)
+
+	next := 0
+	for wi, w := range words {
+		if w == 0 {
+			continue
+		}
+		buf = binary.AppendUvarint(buf, uint64(wi-next))
+		buf = binary.BigEndian.AppendUint64(buf, w)
+		next = wi + 1
+	}
+	return buf
+}
+
+// appendLease encodes a lease as its ID, address and expiry in Unix nanoseconds
+func appendLease(buf []byte, l Lease) []byte {
+	buf = binary.AppendUvarint(buf, uint64(l.ID))
+	buf = appendUint128(buf, fromAddr(l.Addr))
+	return binary.BigEndian.AppendUint64(buf, uint64(l.Expires.UnixNano())) //nolint:gosec // decoded back as int64
+}
+
+// snapshotReader decodes primitive values from a snapshot payload. The first error is kept and every later read
+// returns zero values, so callers can check err once after a sequence of reads.
+type snapshotReader struct {
+	buf []byte
+	err error
+}
+
+// uvarint reads one unsigned varint
+func (r *snapshotReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		r.err = fmt.Errorf("%w: bad varint", ErrSnapshotCorrupt)
+		return 0
+	}
+	r.buf = r.buf[n:]
+	return v
+}
+
+// fixed64 reads one big-endian uint64
+func (r *snapshotReader) fixed64() uint64 {
+	b := r.bytes(8)
+	if r.err != nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+// bytes reads the next n bytes without copying them
+func (r *snapshotReader) bytes(n uint64) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > uint64(len(r.buf)) {
+		r.err = fmt.Errorf("%w: field needs %d bytes, %d left", ErrSnapshotCorrupt, n, len(r.buf))
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+// uvarints reads a count followed by that many unsigned varints
+func (r *snapshotReader) uvarints() []uint64 {
+	n := r.uvarint()
+	// Every varint takes at least one byte, which bounds the allocation below
+	if r.err == nil && n > uint64(len(r.buf)) {
+		r.err = fmt.Errorf("%w: list of %d entries in %d bytes", ErrSnapshotCorrupt, n, len(r.buf))
+	}
+	if r.err != nil {
+		return nil
+	}
+
+	vals := make([]uint64, 0, n)
+	for i := uint64(0); i < n && r.err == nil; i++ {
+		vals = append(vals, r.uvarint())
+	}
+	return vals
+}
+
+// blockWords reads a block encoded by appendBlockWords. The block size must already be known so the word count can
+// be checked before the bitmap is allocated.
+func (r *snapshotReader) blockWords(blockSize uint64) (uint64, []uint64) {
+	idx := r.uvarint()
+	count := r.uvarint()
+	nonZero := r.uvarint()
+	if r.err == nil && (blockSize == 0 || count != (blockSize+63)/64) {
+		r.err = fmt.Errorf("%w: block %d has %d words for block size %d", ErrSnapshotCorrupt, idx, count, blockSize)
+	}
+	if r.err == nil && count > maxBlockWords {
+		r.err = fmt.Errorf("%w: block %d has %d words, at most %d fit in memory", ErrSnapshotCorrupt, idx, count,
+			maxBlockWords)
+	}
+	// Each non-zero word takes at least 9 bytes, and there can't be more of them than words in the block
+	if r.err == nil && (nonZero > count || nonZero > uint64(len(r.buf))/9) {
+		r.err = fmt.Errorf("%w: block %d declares %d non-zero words", ErrSnapshotCorrupt, idx, nonZero)
+	}
+	if r.err != nil {
+		return 0, nil
+	}
+
+	words := make([]uint64, count)
+	var wi uint64
+	for i := uint64(0); i < nonZero && r.err == nil; i++ {
+		wi += r.uvarint()
+		w := r.fixed64()
+		if r.err == nil && wi >= count {
+			r.err = fmt.Errorf("%w: block %d word %d out of range", ErrSnapshotCorrupt, idx, wi)
+		}
+		if r.err != nil {
+			return 0, nil
+		}
+		words[wi] = w
+		wi++
+	}
+	return idx, words
+}
+
+// lease reads a lease encoded by appendLease
+func (r *snapshotReader) lease() Lease {
+	id := LeaseID(r.uvarint())
+	addr := Uint128{Hi: r.fixed64(), Lo: r.fixed64()}
+	expires := time.Unix(0, int64(r.fixed64())) //nolint:gosec // encoded from int64
+	return Lease{ID: id, Addr: addr.toAddr(), Expires: expires}
+}
@@ -1,6 +1,10 @@
 package cidrx //nolint:testpackage // it's OK to be just cidrx
 
 import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
 	"net"
 	"testing"
 )
@@ -79,3 +83,155 @@ func TestSnapshotExhaustion(t *testing.T) {
 		t.Error("expected exhaustion on restored pool, got nil")
 	}
 }
+
+// TestSnapshotBinaryRoundTrip ensures an encoded snapshot decodes to a pool with the same allocation state
+func TestSnapshotBinaryRoundTrip(t *testing.T) {
+	pool, _ := NewPool("2001:db8::", 64, 120, 4)
+	ips := make([]net.IP, 300) // spans two blocks
+	for i := range ips {
+		ip, err := pool.Allocate()
+		if err != nil {
+			t.Fatalf("Allocate error: %v", err)
+		}
+		ips[i] = ip
+	}
+	if err := pool.Release(ips[7]); err != nil {
+		t.Fatalf("Release error: %v", err)
+	}
+
+	data, err := pool.Snapshot().MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary error: %v", err)
+	}
+
+	var snap Snapshot
+	if errUnmarshal := snap.UnmarshalBinary(data); errUnmarshal != nil {
+		t.Fatalf("UnmarshalBinary error: %v", errUnmarshal)
+	}
+	restored, err := NewPoolFromSnapshot(&snap)
+	if err != nil {
+		t.Fatalf("Restore error: %v", err)
+	}
+
+	// The released IP comes back first, and every other IP is still held
+	ip, err := restored.Allocate()
+	if err != nil || !ip.Equal(ips[7]) {
+		t.Fatalf("first restored Allocate = %v, %v; want %v", ip, err, ips[7])
+	}
+	for i, held := range ips {
+		if errRelease := restored.Release(held); errRelease != nil {
+			t.Errorf("Release ips[%d] %v on restored pool: %v", i, held, errRelease)
+		}
+	}
+
+	// Encoding the same state twice must produce identical bytes
+	again, _ := pool.Snapshot().MarshalBinary()
+	if !bytes.Equal(data, again) {
+		t.Error("MarshalBinary is not deterministic")
+	}
+}
+
+// TestSnapshotBinaryTruncated ensures every strict prefix of an encoded snapshot is rejected as truncated
+func TestSnapshotBinaryTruncated(t *testing.T) {
+	pool, _ := NewPool("2001:db8::", 64, 120, 1)
+	_, _ = pool.Allocate()
+	data, _ := pool.Snapshot().MarshalBinary()
+
+	for n := 0; n < len(data); n++ {
+		var snap Snapshot
+		if err := snap.UnmarshalBinary(data[:n]); !errors.Is(err, ErrSnapshotTruncated) {
+			t.Fatalf("UnmarshalBinary(%d of %d bytes) err = %v; want ErrSnapshotTruncated", n, len(data), err)
+		}
+	}
+}
+
+// TestSnapshotBinaryCorrupted ensures damaged input is reported with the matching error
+func TestSnapshotBinaryCorrupted(t *testing.T) {
+	pool, _ := NewPool("2001:db8::", 64, 120, 1)
+	_, _ = pool.Allocate()
+	data, _ := pool.Snapshot().MarshalBinary()
+
+	cases := []struct {
+		name   string
+		mutate func([]byte) []byte
+		want   error
+	}{
+		{"magic", func(b []byte) []byte { b[0] ^= 0xff; return b }, ErrSnapshotCorrupt},
+		{"version", func(b []byte) []byte { b[5]++; return b }, ErrSnapshotVersion},
+		{"payload", func(b []byte) []byte { b[snapshotHeaderLen+3] ^= 0x01; return b }, ErrSnapshotChecksum},
+		{"checksum", func(b []byte) []byte { b[len(b)-1] ^= 0x01; return b }, ErrSnapshotChecksum},
+		{"trailing", func(b []byte) []byte { return append(b, 0) }, ErrSnapshotCorrupt},
+	}
+
+	for _, c := range cases {
+		buf := c.mutate(append([]byte{}, data...))
+		var snap Snapshot
+		if err := snap.UnmarshalBinary(buf); !errors.Is(err, c.want) {
+			t.Errorf("%s: err = %v; want %v", c.name, err, c.want)
+		}
+	}
+}
+
+// TestSnapshotTailBits ensures bits set past the block size are rejected by UnmarshalBinary and dropped by
+// NewPoolFromSnapshot, instead of being counted as allocated addresses
+func TestSnapshotTailBits(t *testing.T) {
+	// /127 blocks hold 2 addresses, a single word with 62 bits past the block size
+	pool, _ := NewPool("2001:db8::", 126, 127, 1)
+	if _, err := pool.Allocate(); err != nil {
+		t.Fatalf("Allocate error: %v", err)
+	}
+	snap := pool.Snapshot()
+	snap.Blocks[0] = []uint64{^uint64(0)}
+
+	data, _ := snap.MarshalBinary()
+	var decoded Snapshot
+	if err := decoded.UnmarshalBinary(data); !errors.Is(err, ErrSnapshotCorrupt) {
+		t.Errorf("UnmarshalBinary err = %v; want ErrSnapshotCorrupt", err)
+	}
+
+	restored, err := NewPoolFromSnapshot(snap)
+	if err != nil {
+		t.Fatalf("NewPoolFromSnapshot error: %v", err)
+	}
+	if ip, errAllocate := restored.Allocate(); errAllocate != nil || !ip.Equal(net.ParseIP("2001:db8::2")) {
+		t.Errorf("Allocate = %v, %v; want 2001:db8::2 from the next block", ip, errAllocate)
+	}
+	if n := restored.allocated(); n != 3 {
+		t.Errorf("allocated() = %d; want 3", n)
+	}
+	if errVerify := restored.Verify(); errVerify != nil {
+		t.Errorf("Verify error: %v", errVerify)
+	}
+}
+
+// TestSnapshotBinaryInconsistent ensures a well-formed encoding of an impossible pool is rejected
+func TestSnapshotBinaryInconsistent(t *testing.T) {
+	pool, _ := NewPool("2001:db8::", 64, 120, 1)
+	_, _ = pool.Allocate()
+	snap := pool.Snapshot()
+	snap.FreeList = append(snap.FreeList, 42)
+
+	data, _ := snap.MarshalBinary()
+	var decoded Snapshot
+	if err := decoded.UnmarshalBinary(data); !errors.Is(err, ErrSnapshotCorrupt) {
+		t.Errorf("UnmarshalBinary err = %v; want ErrSnapshotCorrupt", err)
+	}
+}
+
+// TestSnapshotBinaryHugeBlock ensures a block too large to fit in memory is rejected before its bitmap is allocated
+func TestSnapshotBinaryHugeBlock(t *testing.T) {
+	// /88 blocks hold 2^40 addresses, an empty one only takes a few bytes to encode
+	pool, _ := NewPool("2001:db8::", 64, 88, 1)
+	data, _ := pool.Snapshot().MarshalBinary()
+
+	// Block 0 with 2^34 words, none of them set
+	block := binary.AppendUvarint(binary.AppendUvarint([]byte{0}, uint64(1)<<34), 0)
+	data = appendField(data[:len(data)-snapshotTrailerLen], tagBlock, block)
+	binary.BigEndian.PutUint64(data[snapshotHeaderLen-8:], uint64(len(data)-snapshotHeaderLen))
+	data = binary.BigEndian.AppendUint32(data, crc32.ChecksumIEEE(data))
+
+	var snap Snapshot
+	if err := snap.UnmarshalBinary(data); !errors.Is(err, ErrSnapshotCorrupt) {
+		t.Errorf("UnmarshalBinary(%d bytes) err = %v; want ErrSnapshotCorrupt", len(data), err)
+	}
+}
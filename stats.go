@@ -0,0 +1,95 @@
+package cidrx
+
+import (
+	"maps"
+	"net/netip"
+	"slices"
+)
+
+// PoolStats is a point-in-time view of how full a Pool is. Excluded addresses are counted apart, they are neither
+// allocated nor free.
+type PoolStats struct {
+	Capacity    Uint128 // addresses in the pool network
+	Excluded    Uint128 // addresses excluded from the pool network
+	Allocated   uint64  // addresses handed out, reserved or leased
+	Free        uint64  // free addresses in the blocks created so far
+	Blocks      int     // blocks created so far
+	BitmapBytes uint64  // memory held by the bitmaps of those blocks, summaries included
+	FreeListLen int     // entries in the free list
+	SpareBytes  uint64  // memory held by reclaimed bitmaps kept for reuse, see WithBitmapRecycling
+}
+
+// Utilization returns the fraction of the addresses that can be handed out which are allocated, between 0 and 1.
+func (s PoolStats) Utilization() float64 {
+	usable := s.Capacity.sub(s.Excluded)
+	if usable == (Uint128{}) {
+		return 1
+	}
+	return float64(s.Allocated) / usable.float64()
+}
+
+// BlockStats describes the usage of one created block.
+type BlockStats struct {
+	Index     uint64       // block index within the pool
+	Prefix    netip.Prefix // addresses covered by the block
+	Size      uint64       // addresses in the block
+	Allocated uint64       // addresses handed out, reserved or leased
+	Excluded  uint64       // addresses excluded from the pool
+	Free      uint64       // addresses still available
+}
+
+// Utilization returns the fraction of the block's usable addresses which are allocated, between 0 and 1.
+func (s BlockStats) Utilization() float64 {
+	usable := s.Size - s.Excluded
+	if usable == 0 {
+		return 1
+	}
+	return float64(s.Allocated) / float64(usable)
+}
+
+// Stats returns the current usage of the pool.
+func (p *Pool) Stats() PoolStats {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	st := PoolStats{
+		Capacity:    Uint128{Lo: p.maxBlocks}.lsh(p.hostBits),
+		Blocks:      len(p.blocks),
+		FreeListLen: len(p.freeList),
+		SpareBytes:  p.spareBytes,
+	}
+	for _, r := range p.excluded {
+		st.Excluded = st.Excluded.add(r.last.sub(r.first).add(Uint128{Lo: 1}))
+	}
+	for _, blk := range p.blocks {
+		st.Allocated += blk.size - blk.freeCount - blk.excluded
+		st.Free += blk.freeCount
+		st.BitmapBytes += blk.bitmapBytes()
+	}
+	return st
+}
+
+// BlockStats returns the usage of every created block, in index order.
+func (p *Pool) BlockStats() []BlockStats {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	out := make([]BlockStats, 0, len(p.blocks))
+	for _, bi := range slices.Sorted(maps.Keys(p.blocks)) {
+		out = append(out, p.blockStats(bi, p.blocks[bi]))
+	}
+	return out
+}
+
+// blockStats returns the usage of block bi, the caller must hold p.mu
+func (p *Pool) blockStats(bi uint64, blk *block) BlockStats {
+	base := p.outAddr(blk.base.toAddr())
+	return BlockStats{
+		Index:     bi,
+		Prefix:    netip.PrefixFrom(base, base.BitLen()-int(p.hostBits)),
+		Size:      blk.size,
+		Allocated: blk.size - blk.freeCount - blk.excluded,
+		Excluded:  blk.excluded,
+		Free:      blk.freeCount,
+	}
+}
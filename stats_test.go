@@ -0,0 +1,82 @@
+package cidrx //nolint:testpackage // it's OK to be just cidrx
+
+import (
+	"net/netip"
+	"testing"
+)
+
+// TestStats ensures Stats reports capacity, allocations and exclusions as separate counts
+func TestStats(t *testing.T) {
+	// /120 with /124 blocks => 16 blocks of 16 addresses
+	pool, err := NewPoolFromPrefix(netip.MustParsePrefix("2001:db8::/120"), 124, 4,
+		WithExcludedPrefixes(netip.MustParsePrefix("2001:db8::/126")))
+	if err != nil {
+		t.Fatalf("NewPoolFromPrefix error: %v", err)
+	}
+
+	empty := pool.Stats()
+	if empty.Capacity != (Uint128{Lo: 256}) || empty.Excluded != (Uint128{Lo: 4}) {
+		t.Errorf("Capacity, Excluded = %v, %v; want 256, 4", empty.Capacity, empty.Excluded)
+	}
+	if empty.Allocated != 0 || empty.Blocks != 0 || empty.BitmapBytes != 0 {
+		t.Errorf("empty pool stats = %+v; want nothing allocated nor created", empty)
+	}
+
+	for i := 0; i < 20; i++ {
+		if _, errAllocate := pool.Allocate(); errAllocate != nil {
+			t.Fatalf("Allocate #%d error: %v", i, errAllocate)
+		}
+	}
+	if errRelease := pool.ReleaseAddr(netip.MustParseAddr("2001:db8::11")); errRelease != nil {
+		t.Fatalf("ReleaseAddr error: %v", errRelease)
+	}
+
+	st := pool.Stats()
+	// Block 0 holds 12 allocations after its 4 excluded addresses, block 1 holds 8 and one was released
+	if st.Allocated != 19 || st.Free != 9 || st.Blocks != 2 {
+		t.Errorf("Allocated, Free, Blocks = %d, %d, %d; want 19, 9, 2", st.Allocated, st.Free, st.Blocks)
+	}
+	if st.BitmapBytes != 2*2*8 {
+		t.Errorf("BitmapBytes = %d; want 32 (one word and one summary word per block)", st.BitmapBytes)
+	}
+	if st.FreeListLen == 0 {
+		t.Errorf("FreeListLen = 0; want block 1 listed")
+	}
+	if u := st.Utilization(); u != 19.0/252 {
+		t.Errorf("Utilization() = %v; want %v", u, 19.0/252)
+	}
+}
+
+// TestBlockStats ensures per-block usage is reported in index order with the block prefix
+func TestBlockStats(t *testing.T) {
+	pool, err := NewPool("10.0.0.0", 24, 28, 4,
+		WithExcludedRanges(AddrRange{First: netip.MustParseAddr("10.0.0.0"), Last: netip.MustParseAddr("10.0.0.1")}))
+	if err != nil {
+		t.Fatalf("NewPool error: %v", err)
+	}
+	for i := 0; i < 14; i++ {
+		if _, errAllocate := pool.Allocate(); errAllocate != nil {
+			t.Fatalf("Allocate #%d error: %v", i, errAllocate)
+		}
+	}
+	if errReserve := pool.ReserveAddr(netip.MustParseAddr("10.0.0.200")); errReserve != nil {
+		t.Fatalf("ReserveAddr error: %v", errReserve)
+	}
+
+	got := pool.BlockStats()
+	want := []BlockStats{
+		{Index: 0, Prefix: netip.MustParsePrefix("10.0.0.0/28"), Size: 16, Allocated: 14, Excluded: 2, Free: 0},
+		{Index: 12, Prefix: netip.MustParsePrefix("10.0.0.192/28"), Size: 16, Allocated: 1, Free: 15},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("BlockStats() = %+v; want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("BlockStats()[%d] = %+v; want %+v", i, got[i], want[i])
+		}
+	}
+	if u := got[0].Utilization(); u != 1 {
+		t.Errorf("block 0 Utilization() = %v; want 1", u)
+	}
+}
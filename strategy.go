@@ -0,0 +1,231 @@
+package cidrx
+
+import (
+	"crypto/rand"
+	mathrand "math/rand/v2"
+)
+
+// AllocationStrategy decides which free address a Pool hands out next. By default a pool takes the lowest free
+// address of the block that was listed last, so a released address is handed out again right away; the strategies
+// below trade some speed for other orders. Strategies work on the pool internals, so only the ones returned by this
+// package can be used. They keep state for the pool they are given to and must not be shared between pools.
+type AllocationStrategy interface {
+	// allocate claims a free bit and returns its block index and bit index, the caller holds p.mu
+	allocate(p *Pool) (uint64, uint64, error)
+	// freed is told about every bit cleared in block bi, including those of allocations undone by a failing operation
+	freed(bi uint64)
+	// released is told about every address handed back through Release or the lease reaper
+	released(u Uint128)
+}
+
+// WithAllocationStrategy makes the pool pick the addresses it hands out with s. It applies to Allocate, AllocateAddr,
+// AllocateN and AllocateLease, while AllocateContiguous always takes the lowest run that fits. The strategy state is
+// not part of snapshots, a restored pool starts it over.
+func WithAllocationStrategy(s AllocationStrategy) Option {
+	return func(p *Pool) {
+		p.strategy = s
+	}
+}
+
+// FirstFit returns a strategy that always hands out the lowest free address of the pool.
+func FirstFit() AllocationStrategy {
+	return &firstFit{}
+}
+
+// RandomFit returns a strategy that hands out an unpredictable free address, for privacy. The block is picked at random
+// among those with free addresses and the address at random within it, from a generator seeded by crypto/rand. Blocks
+// are still created in order, so pools should use large blocks for addresses to be hard to guess.
+func RandomFit() AllocationStrategy {
+	var seed [32]byte
+	_, _ = rand.Read(seed[:]) // never fails, see crypto/rand.Read
+	return &randomFit{rng: mathrand.New(mathrand.NewChaCha8(seed))}
+}
+
+// RoundRobin returns a strategy that hands out the first free address after the one handed out last, wrapping around
+// at the end of the network, so a released address is only reused once every other one was.
+func RoundRobin() AllocationStrategy {
+	return &roundRobin{}
+}
+
+// LeastRecentlyReleased returns a strategy that hands out addresses that were never handed out first, in address
+// order, and then the released ones in the order they were released, oldest first. Released addresses are remembered
+// until they are handed out again. When hold is positive at most hold of them are remembered: once that many are
+// waiting the oldest is handed out before any fresh address, and further releases are not remembered until it is, so
+// those addresses are only handed out once neither fresh nor remembered ones are left. With hold ≤ 0 every released
+// address is remembered, and reused once the pool has no fresh address left.
+func LeastRecentlyReleased(hold int) AllocationStrategy {
+	return &leastRecentlyReleased{hold: hold}
+}
+
+// firstFit keeps a cursor below which every block is full
+type firstFit struct {
+	cursor uint64
+}
+
+func (s *firstFit) allocate(p *Pool) (uint64, uint64, error) {
+	bi, blk, ok := p.freeBlockFrom(s.cursor)
+	if !ok {
+		s.cursor = p.maxBlocks
+		return 0, 0, ErrPoolExhausted
+	}
+	s.cursor = bi
+
+	idx, err := blk.allocBit()
+	if err != nil {
+		return 0, 0, err
+	}
+	p.relist(bi, blk)
+	return bi, idx, nil
+}
+
+func (s *firstFit) freed(bi uint64) {
+	s.cursor = min(s.cursor, bi)
+}
+
+func (s *firstFit) released(Uint128) {}
+
+// randomFit picks blocks and addresses with rng
+type randomFit struct {
+	rng *mathrand.Rand
+}
+
+func (s *randomFit) allocate(p *Pool) (uint64, uint64, error) {
+	var bi uint64
+	var blk *block
+	if len(p.freeList) > 0 {
+		bi = p.freeList[s.rng.IntN(len(p.freeList))]
+		blk = p.blocks[bi]
+	} else {
+		var ok bool
+		if bi, blk, ok = p.allocBlock(); !ok {
+			return 0, 0, ErrPoolExhausted
+		}
+		if blk.freeCount == 0 {
+			return 0, 0, ErrBlockFull
+		}
+	}
+
+	idx := blk.allocRandom(s.rng)
+	p.relist(bi, blk)
+	return bi, idx, nil
+}
+
+func (s *randomFit) freed(uint64) {}
+
+func (s *randomFit) released(Uint128) {}
+
+// roundRobin points right after the address handed out last
+type roundRobin struct {
+	bi, idx uint64
+}
+
+func (s *roundRobin) allocate(p *Pool) (uint64, uint64, error) {
+	return s.next(p, true)
+}
+
+func (s *roundRobin) freed(uint64) {}
+
+func (s *roundRobin) released(Uint128) {}
+
+// next claims the first free bit at or after the cursor and moves the cursor past it. When wrap is set the search
+// continues from the start of the network once it reaches the end.
+func (s *roundRobin) next(p *Pool, wrap bool) (uint64, uint64, error) {
+	bi, from := s.bi, s.idx
+	for {
+		if blk, ok := p.blocks[bi]; ok {
+			if idx, found := blk.allocFrom(from); found {
+				p.relist(bi, blk)
+				s.bi, s.idx = bi, idx+1
+				return bi, idx, nil
+			}
+			bi, from = bi+1, 0
+		}
+
+		next, _, ok := p.freeBlockFrom(bi)
+		if !ok && wrap {
+			wrap = false
+			next, _, ok = p.freeBlockFrom(0)
+		}
+		if !ok {
+			return 0, 0, ErrPoolExhausted
+		}
+		if next != bi {
+			// Unless the cursor block was reclaimed and just created again, the search starts over in a new block
+			from = 0
+		}
+		bi = next
+	}
+}
+
+// leastRecentlyReleased hands out the addresses beyond a cursor that never wraps, then queued released ones. An
+// address is queued at most once: if it is taken again by other means, such as Reserve, its entry stays and is
+// skipped when it comes up, unless the address was released again by then.
+type leastRecentlyReleased struct {
+	fresh  roundRobin
+	queue  []Uint128 // released addresses, oldest first from head
+	head   int
+	queued map[Uint128]struct{}
+	hold   int
+}
+
+func (s *leastRecentlyReleased) allocate(p *Pool) (uint64, uint64, error) {
+	if s.hold <= 0 || len(s.queue)-s.head < s.hold {
+		if bi, idx, err := s.fresh.next(p, false); err == nil {
+			return bi, idx, nil
+		}
+	}
+
+	for s.head < len(s.queue) {
+		u := s.queue[s.head]
+		s.head++
+		delete(s.queued, u)
+		if s.head == len(s.queue) {
+			s.queue, s.head = s.queue[:0], 0
+		} else if s.head > len(s.queue)/2 {
+			s.queue, s.head = append(s.queue[:0], s.queue[s.head:]...), 0
+		}
+
+		// Reserved again since it was queued, otherwise it is handed out
+		if bi, idx, err := p.claim(u); err == nil {
+			return bi, idx, nil
+		}
+	}
+
+	// The queue only held addresses reserved again since, so try fresh addresses before the ones that were already
+	// free when the pool got this strategy, e.g. in a restored snapshot
+	if bi, idx, err := s.fresh.next(p, false); err == nil {
+		return bi, idx, nil
+	}
+	return p.allocateLast()
+}
+
+func (s *leastRecentlyReleased) freed(uint64) {}
+
+func (s *leastRecentlyReleased) released(u Uint128) {
+	if _, ok := s.queued[u]; ok || (s.hold > 0 && len(s.queue)-s.head >= s.hold) {
+		return
+	}
+	if s.queued == nil {
+		s.queued = make(map[Uint128]struct{})
+	}
+	s.queued[u] = struct{}{}
+	s.queue = append(s.queue, u)
+}
+
+// freeBlockFrom returns the first block at or after bi that has a free address, creating it if it does not exist yet.
+// Returns false if there is none up to the end of the network.
+func (p *Pool) freeBlockFrom(bi uint64) (uint64, *block, bool) {
+	for {
+		if bi = p.skipExcluded(bi); bi >= p.maxBlocks {
+			return 0, nil, false
+		}
+		blk, ok := p.blocks[bi]
+		if !ok {
+			blk = p.createBlock(bi)
+		}
+		if blk.freeCount > 0 {
+			return bi, blk, true
+		}
+		bi++
+	}
+}
@@ -0,0 +1,223 @@
+package cidrx //nolint:testpackage // it's OK to be just cidrx
+
+import (
+	"errors"
+	"math/rand/v2"
+	"net/netip"
+	"slices"
+	"testing"
+)
+
+// releaseAll releases addrs from pool in order, failing the test on error
+func releaseAll(t *testing.T, pool *Pool, addrs ...string) {
+	t.Helper()
+	for _, s := range addrs {
+		if err := pool.ReleaseAddr(netip.MustParseAddr(s)); err != nil {
+			t.Fatalf("ReleaseAddr(%s) error: %v", s, err)
+		}
+	}
+}
+
+// TestFirstFit ensures FirstFit always hands out the lowest free address, across blocks
+func TestFirstFit(t *testing.T) {
+	pool, err := NewPool("10.0.0.0", 24, 28, 1, WithAllocationStrategy(FirstFit()))
+	if err != nil {
+		t.Fatalf("NewPool error: %v", err)
+	}
+	fillBlocks(t, pool, 40)
+	releaseAll(t, pool, "10.0.0.20", "10.0.0.5", "10.0.0.39")
+
+	for _, want := range []string{"10.0.0.5", "10.0.0.20", "10.0.0.39", "10.0.0.40"} {
+		if addr, errAllocate := pool.AllocateAddr(); errAllocate != nil || addr != netip.MustParseAddr(want) {
+			t.Errorf("AllocateAddr = %s, %v; want %s", addr, errAllocate, want)
+		}
+	}
+}
+
+// TestRoundRobin ensures RoundRobin only reuses a released address once it went around the whole network
+func TestRoundRobin(t *testing.T) {
+	pool, err := NewPool("10.0.0.0", 24, 28, 1, WithAllocationStrategy(RoundRobin()))
+	if err != nil {
+		t.Fatalf("NewPool error: %v", err)
+	}
+	fillBlocks(t, pool, 5)
+	releaseAll(t, pool, "10.0.0.1")
+
+	rest := fillBlocks(t, pool, 251)
+	if rest[0] != netip.MustParseAddr("10.0.0.5") || rest[250] != netip.MustParseAddr("10.0.0.255") {
+		t.Errorf("AllocateAddr went from %s to %s; want 10.0.0.5 to 10.0.0.255", rest[0], rest[250])
+	}
+	if addr, errAllocate := pool.AllocateAddr(); errAllocate != nil || addr != netip.MustParseAddr("10.0.0.1") {
+		t.Errorf("AllocateAddr after wrapping = %s, %v; want 10.0.0.1", addr, errAllocate)
+	}
+	if _, errAllocate := pool.AllocateAddr(); !errors.Is(errAllocate, ErrPoolExhausted) {
+		t.Errorf("AllocateAddr on a full pool err = %v; want ErrPoolExhausted", errAllocate)
+	}
+}
+
+// TestLeastRecentlyReleased ensures fresh addresses come first and released ones are reused oldest first
+func TestLeastRecentlyReleased(t *testing.T) {
+	pool, err := NewPool("2001:db8::", 120, 124, 1, WithAllocationStrategy(LeastRecentlyReleased(0)))
+	if err != nil {
+		t.Fatalf("NewPool error: %v", err)
+	}
+	fillBlocks(t, pool, 4)
+	releaseAll(t, pool, "2001:db8::2", "2001:db8::0")
+	if addr, errAllocate := pool.AllocateAddr(); errAllocate != nil || addr != netip.MustParseAddr("2001:db8::4") {
+		t.Errorf("AllocateAddr = %s, %v; want the fresh 2001:db8::4", addr, errAllocate)
+	}
+
+	fillBlocks(t, pool, 251)
+	releaseAll(t, pool, "2001:db8::80", "2001:db8::7")
+	if errReserve := pool.ReserveAddr(netip.MustParseAddr("2001:db8::0")); errReserve != nil {
+		t.Fatalf("ReserveAddr error: %v", errReserve)
+	}
+	for _, want := range []string{"2001:db8::2", "2001:db8::80", "2001:db8::7"} {
+		if addr, errAllocate := pool.AllocateAddr(); errAllocate != nil || addr != netip.MustParseAddr(want) {
+			t.Errorf("AllocateAddr = %s, %v; want %s", addr, errAllocate, want)
+		}
+	}
+	if _, errAllocate := pool.AllocateAddr(); !errors.Is(errAllocate, ErrPoolExhausted) {
+		t.Errorf("AllocateAddr on a full pool err = %v; want ErrPoolExhausted", errAllocate)
+	}
+}
+
+// TestLeastRecentlyReleasedHold ensures a released address is reused once hold newer ones were released, even with
+// fresh addresses left
+func TestLeastRecentlyReleasedHold(t *testing.T) {
+	pool, err := NewPool("2001:db8::", 64, 120, 1, WithAllocationStrategy(LeastRecentlyReleased(2)))
+	if err != nil {
+		t.Fatalf("NewPool error: %v", err)
+	}
+	fillBlocks(t, pool, 4)
+	releaseAll(t, pool, "2001:db8::2", "2001:db8::0")
+	if addr, errAllocate := pool.AllocateAddr(); errAllocate != nil || addr != netip.MustParseAddr("2001:db8::2") {
+		t.Errorf("AllocateAddr with 2 held = %s, %v; want the oldest released 2001:db8::2", addr, errAllocate)
+	}
+	if addr, errAllocate := pool.AllocateAddr(); errAllocate != nil || addr != netip.MustParseAddr("2001:db8::4") {
+		t.Errorf("AllocateAddr with 1 held = %s, %v; want the fresh 2001:db8::4", addr, errAllocate)
+	}
+
+	// Releases keep going back to the oldest held addresses, so the queue never grows past hold
+	for i := 0; i < 1000; i++ {
+		addr, errAllocate := pool.AllocateAddr()
+		if errAllocate != nil {
+			t.Fatalf("AllocateAddr error: %v", errAllocate)
+		}
+		if errRelease := pool.ReleaseAddr(addr); errRelease != nil {
+			t.Fatalf("ReleaseAddr error: %v", errRelease)
+		}
+	}
+	if s := pool.strategy.(*leastRecentlyReleased); len(s.queue)-s.head > 2 {
+		t.Errorf("%d released addresses held; want at most 2", len(s.queue)-s.head)
+	}
+}
+
+// TestLeastRecentlyReleasedQueue ensures an address is queued once however often it is reserved and released again,
+// and never when an allocation is undone
+func TestLeastRecentlyReleasedQueue(t *testing.T) {
+	strategy := LeastRecentlyReleased(4)
+	j, err := OpenJournal(t.TempDir(), JournalOptions{}, func() (*Pool, error) {
+		return NewPool("2001:db8::", 64, 120, 1, WithAllocationStrategy(strategy))
+	})
+	if err != nil {
+		t.Fatalf("OpenJournal error: %v", err)
+	}
+	pool := j.Pool()
+	addr := netip.MustParseAddr("2001:db8::7")
+	for i := 0; i < 1000; i++ {
+		if errReserve := pool.ReserveAddr(addr); errReserve != nil {
+			t.Fatalf("ReserveAddr error: %v", errReserve)
+		}
+		releaseAll(t, pool, addr.String())
+	}
+
+	// Once the journal fails, allocations are undone and their addresses must not be queued
+	crashJournal(t, j)
+	for i := 0; i < 10; i++ {
+		if _, errAllocate := pool.AllocateAddr(); errAllocate == nil {
+			t.Fatal("AllocateAddr with a closed journal succeeded")
+		}
+	}
+	lrr, _ := strategy.(*leastRecentlyReleased)
+	if queued := lrr.queue[lrr.head:]; !slices.Equal(queued, []Uint128{fromAddr(addr)}) {
+		t.Errorf("queue = %v; want only %s", queued, addr)
+	}
+}
+
+// TestRandomFit ensures RandomFit hands out every address exactly once, in no predictable order
+func TestRandomFit(t *testing.T) {
+	pool, err := NewPool("2001:db8::", 116, 120, 1, WithAllocationStrategy(RandomFit()))
+	if err != nil {
+		t.Fatalf("NewPool error: %v", err)
+	}
+	addrs := fillBlocks(t, pool, 4096)
+	if _, errAllocate := pool.AllocateAddr(); !errors.Is(errAllocate, ErrPoolExhausted) {
+		t.Errorf("AllocateAddr on a full pool err = %v; want ErrPoolExhausted", errAllocate)
+	}
+
+	if slices.IsSortedFunc(addrs[:256], netip.Addr.Compare) {
+		t.Errorf("the first block was handed out in address order")
+	}
+	slices.SortFunc(addrs, netip.Addr.Compare)
+	if len(slices.Compact(addrs)) != 4096 {
+		t.Errorf("RandomFit handed out duplicates")
+	}
+}
+
+// TestAllocationStrategiesConsistent ensures every strategy keeps the pool consistent under random allocations,
+// releases and reservations, with exclusions and block reclaim in place
+func TestAllocationStrategiesConsistent(t *testing.T) {
+	strategies := map[string]func() AllocationStrategy{
+		"FirstFit":                  FirstFit,
+		"RandomFit":                 RandomFit,
+		"RoundRobin":                RoundRobin,
+		"LeastRecentlyReleased":     func() AllocationStrategy { return LeastRecentlyReleased(0) },
+		"LeastRecentlyReleasedHold": func() AllocationStrategy { return LeastRecentlyReleased(64) },
+	}
+	for name, strategy := range strategies {
+		t.Run(name, func(t *testing.T) {
+			// /108 blocks hold 16384 words, so the summary has three levels
+			pool, err := NewPool("2001:db8::", 104, 108, 1, WithAllocationStrategy(strategy()), WithBlockReclaim(0),
+				WithExcludedPrefixes(netip.MustParsePrefix("2001:db8::10:0/108"),
+					netip.MustParsePrefix("2001:db8::20:0/112")))
+			if err != nil {
+				t.Fatalf("NewPool error: %v", err)
+			}
+
+			rng := rand.New(rand.NewPCG(1, 2))
+			held := make(map[netip.Addr]bool)
+			var order []netip.Addr
+			for i := 0; i < 20000; i++ {
+				switch {
+				case len(order) > 0 && rng.IntN(3) == 0:
+					j := rng.IntN(len(order))
+					addr := order[j]
+					order[j] = order[len(order)-1]
+					order = order[:len(order)-1]
+					delete(held, addr)
+					if errRelease := pool.ReleaseAddr(addr); errRelease != nil {
+						t.Fatalf("ReleaseAddr(%s) error: %v", addr, errRelease)
+					}
+				default:
+					addr, errAllocate := pool.AllocateAddr()
+					if errAllocate != nil {
+						t.Fatalf("AllocateAddr error: %v", errAllocate)
+					}
+					if held[addr] || !pool.Contains(addr) {
+						t.Fatalf("AllocateAddr returned %s, already held or outside the pool", addr)
+					}
+					held[addr] = true
+					order = append(order, addr)
+				}
+			}
+
+			if n := pool.allocated(); n != uint64(len(held)) {
+				t.Errorf("allocated() = %d; want %d", n, len(held))
+			}
+			if errVerify := pool.Verify(); errVerify != nil {
+				t.Errorf("Verify error: %v", errVerify)
+			}
+		})
+	}
+}
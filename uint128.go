@@ -1,9 +1,11 @@
 package cidrx
 
 import (
+	"cmp"
 	"encoding/binary"
 	"math/bits"
 	"net"
+	"net/netip"
 )
 
 // Uint128 represents a 128-bit unsigned integer as two 64-bit words
@@ -26,6 +28,24 @@ func (x Uint128) sub(y Uint128) Uint128 {
 	return Uint128{Hi: hi, Lo: lo}
 }
 
+// andNot returns the bitwise x &^ y
+func (x Uint128) andNot(y Uint128) Uint128 {
+	return Uint128{Hi: x.Hi &^ y.Hi, Lo: x.Lo &^ y.Lo}
+}
+
+// xor returns the bitwise x ^ y
+func (x Uint128) xor(y Uint128) Uint128 {
+	return Uint128{Hi: x.Hi ^ y.Hi, Lo: x.Lo ^ y.Lo}
+}
+
+// cmp compares x and y, returning -1, 0 or +1
+func (x Uint128) cmp(y Uint128) int {
+	if c := cmp.Compare(x.Hi, y.Hi); c != 0 {
+		return c
+	}
+	return cmp.Compare(x.Lo, y.Lo)
+}
+
 // lsh shifts x left by k bits (0<=k<128)
 func (x Uint128) lsh(k uint) Uint128 {
 	if k >= 64 {
@@ -36,6 +56,20 @@ func (x Uint128) lsh(k uint) Uint128 {
 	return Uint128{Hi: hi, Lo: lo}
 }
 
+// hostMask returns a value with the low k bits set (0<=k<=128)
+func hostMask(k uint) Uint128 {
+	// 1<<128 wraps to zero, and zero minus one sets every bit
+	return Uint128{Lo: 1}.lsh(k).sub(Uint128{Lo: 1})
+}
+
+// trailingZeros returns the number of trailing zero bits in x, 128 for zero
+func (x Uint128) trailingZeros() int {
+	if x.Lo != 0 {
+		return bits.TrailingZeros64(x.Lo)
+	}
+	return 64 + bits.TrailingZeros64(x.Hi)
+}
+
 // rsh shifts x right by k bits (0<=k<128)
 func (x Uint128) rsh(k uint) Uint128 {
 	if k >= 64 {
@@ -46,6 +80,11 @@ func (x Uint128) rsh(k uint) Uint128 {
 	return Uint128{Hi: hi, Lo: lo}
 }
 
+// float64 returns x as the nearest float64
+func (x Uint128) float64() float64 {
+	return float64(x.Hi)*(1<<64) + float64(x.Lo)
+}
+
 // toIP converts a Uint128 to a 16-byte net.IP
 func (x Uint128) toIP() net.IP {
 	buf := make([]byte, 16)
@@ -61,3 +100,19 @@ func fromIP(ip net.IP) Uint128 {
 	lo := binary.BigEndian.Uint64(b[8:])
 	return Uint128{Hi: hi, Lo: lo}
 }
+
+// toAddr converts a Uint128 to an IPv6 netip.Addr
+func (x Uint128) toAddr() netip.Addr {
+	var buf [16]byte
+	binary.BigEndian.PutUint64(buf[:8], x.Hi)
+	binary.BigEndian.PutUint64(buf[8:], x.Lo)
+	return netip.AddrFrom16(buf)
+}
+
+// fromAddr converts a netip.Addr into a Uint128, IPv4 addresses are taken in their IPv4-mapped form
+func fromAddr(addr netip.Addr) Uint128 {
+	b := addr.As16()
+	hi := binary.BigEndian.Uint64(b[:8])
+	lo := binary.BigEndian.Uint64(b[8:])
+	return Uint128{Hi: hi, Lo: lo}
+}
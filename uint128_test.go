@@ -48,3 +48,13 @@ func TestUint128IPConversion(t *testing.T) {
 		}
 	}
 }
+
+func TestUint128Bitwise(t *testing.T) {
+	x := Uint128{Hi: 0xff, Lo: 0xf0f0}
+	if got := x.andNot(Uint128{Lo: 0xff}); got != (Uint128{Hi: 0xff, Lo: 0xf000}) {
+		t.Errorf("andNot: got %v", got)
+	}
+	if got := x.xor(Uint128{Hi: 0x0f, Lo: 0xff}); got != (Uint128{Hi: 0xf0, Lo: 0xf00f}) {
+		t.Errorf("xor: got %v", got)
+	}
+}
@@ -0,0 +1,111 @@
+package cidrx
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// Verify checks the internal invariants of the pool: the free list lists every block with a free address exactly
+// once, each block's free count and summary match its bitmap, excluded addresses are marked and every lease is on an
+// allocated address. It returns an error wrapping ErrInconsistent for the first violation found. Verify walks every
+// bitmap and is meant for tests and debugging, not for the hot path.
+func (p *Pool) Verify() error {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if p.nextBlockIndex > p.maxBlocks {
+		return fmt.Errorf("%w: next block %d beyond %d blocks", ErrInconsistent, p.nextBlockIndex, p.maxBlocks)
+	}
+
+	for pos, bi := range p.freeList {
+		blk, ok := p.blocks[bi]
+		switch {
+		case !ok:
+			return fmt.Errorf("%w: free list entry %d references missing block %d", ErrInconsistent, pos, bi)
+		case !blk.listed || blk.listPos != pos:
+			return fmt.Errorf("%w: block %d listed at %d, block records listed=%v at %d",
+				ErrInconsistent, bi, pos, blk.listed, blk.listPos)
+		}
+	}
+
+	for bi, blk := range p.blocks {
+		if err := p.verifyBlock(bi, blk); err != nil {
+			return err
+		}
+	}
+
+	for id, l := range p.leases {
+		u := fromAddr(l.Addr)
+		if p.leaseOf[u] != id || !p.isAllocated(u) {
+			return fmt.Errorf("%w: lease %d on %s not indexed or not allocated", ErrInconsistent, id, l.Addr)
+		}
+	}
+	if len(p.leaseOf) != len(p.leases) {
+		return fmt.Errorf("%w: %d leases but %d indexed addresses", ErrInconsistent, len(p.leases), len(p.leaseOf))
+	}
+	for i, l := range p.expiry {
+		if l.index != i || p.leases[l.ID] != l {
+			return fmt.Errorf("%w: lease %d misplaced in the expiry heap", ErrInconsistent, l.ID)
+		}
+	}
+	if len(p.expiry) != len(p.leases) {
+		return fmt.Errorf("%w: %d leases but %d expiries", ErrInconsistent, len(p.leases), len(p.expiry))
+	}
+	return nil
+}
+
+// verifyBlock checks the bitmap, counters, summary and listing of block bi
+func (p *Pool) verifyBlock(bi uint64, blk *block) error {
+	if base := p.networkAddr.add(Uint128{Lo: bi}.lsh(p.hostBits)); blk.base != base || blk.size != p.blockSize {
+		return fmt.Errorf("%w: block %d does not cover its part of the network", ErrInconsistent, bi)
+	}
+
+	var used uint64
+	for _, w := range blk.used {
+		used += uint64(bits.OnesCount64(w))
+	}
+	if tail := blk.size % 64; tail != 0 && blk.used[len(blk.used)-1]>>tail != 0 {
+		return fmt.Errorf("%w: block %d has bits set past its end", ErrInconsistent, bi)
+	}
+	if blk.freeCount != blk.size-used {
+		return fmt.Errorf("%w: block %d free count %d, bitmap has %d free", ErrInconsistent, bi, blk.freeCount,
+			blk.size-used)
+	}
+	if blk.listed != (blk.freeCount > 0) {
+		return fmt.Errorf("%w: block %d with %d free addresses listed=%v", ErrInconsistent, bi, blk.freeCount,
+			blk.listed)
+	}
+
+	var excluded uint64
+	var unmarked bool
+	p.excludedSpans(blk.base, blk.size, func(from, to uint64) {
+		excluded += to - from + 1
+		for wi := from / 64; wi <= to/64; wi++ {
+			mask := spanMask(wi, from, to)
+			unmarked = unmarked || blk.used[wi]&mask != mask
+		}
+	})
+	if unmarked || blk.excluded != excluded {
+		return fmt.Errorf("%w: block %d counts %d excluded addresses, %d expected and all marked=%v",
+			ErrInconsistent, bi, blk.excluded, excluded, !unmarked)
+	}
+
+	// Each summary level must be exactly what the level below implies
+	lower := len(blk.used)
+	for l, level := range blk.summary {
+		for i := 0; i < lower; i++ {
+			want := level[i/64]&(1<<(i%64)) != 0
+			var hasFree bool
+			if l == 0 {
+				hasFree = !blk.wordFull(uint64(i))
+			} else {
+				hasFree = blk.summary[l-1][i] != 0
+			}
+			if want != hasFree {
+				return fmt.Errorf("%w: block %d summary level %d bit %d out of date", ErrInconsistent, bi, l, i)
+			}
+		}
+		lower = len(level)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package cidrx //nolint:testpackage // it's OK to be just cidrx
+
+import (
+	"errors"
+	"math/rand/v2"
+	"net/netip"
+	"testing"
+)
+
+// TestFreeListNoDuplicates ensures repeated releases into one block list it only once
+func TestFreeListNoDuplicates(t *testing.T) {
+	pool, err := NewPool("2001:db8::", 64, 120, 4)
+	if err != nil {
+		t.Fatalf("NewPool error: %v", err)
+	}
+	addrs := fillBlocks(t, pool, 100)
+	for _, addr := range addrs[:50] {
+		if errRelease := pool.ReleaseAddr(addr); errRelease != nil {
+			t.Fatalf("ReleaseAddr error: %v", errRelease)
+		}
+	}
+
+	if n := len(pool.freeList); n != 1 {
+		t.Errorf("freeList holds %d entries; want 1", n)
+	}
+	if errVerify := pool.Verify(); errVerify != nil {
+		t.Errorf("Verify error: %v", errVerify)
+	}
+}
+
+// TestVerifyRandomOperations ensures the invariants hold after every step of a random sequence of operations
+func TestVerifyRandomOperations(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	// /120 with /126 blocks => 64 blocks of 4 addresses
+	pool, err := NewPoolFromPrefix(netip.MustParsePrefix("2001:db8::/120"), 126, 4,
+		WithExcludedPrefixes(netip.MustParsePrefix("2001:db8::10/126"), netip.MustParsePrefix("2001:db8::21/128")),
+		WithBlockReclaim(0))
+	if err != nil {
+		t.Fatalf("NewPoolFromPrefix error: %v", err)
+	}
+
+	held := make(map[netip.Addr]bool)
+	for step := 0; step < 5000; step++ {
+		switch op := rng.IntN(10); {
+		case op < 5:
+			if addr, errAllocate := pool.AllocateAddr(); errAllocate == nil {
+				held[addr] = true
+			} else if !errors.Is(errAllocate, ErrPoolExhausted) {
+				t.Fatalf("step %d: AllocateAddr error: %v", step, errAllocate)
+			}
+		case op < 8:
+			for addr := range held {
+				if errRelease := pool.ReleaseAddr(addr); errRelease != nil {
+					t.Fatalf("step %d: ReleaseAddr(%s) error: %v", step, addr, errRelease)
+				}
+				delete(held, addr)
+				break
+			}
+		case op < 9:
+			addr := netip.AddrFrom16([16]byte{0x20, 0x01, 0x0d, 0xb8, 15: byte(rng.IntN(256))})
+			if errReserve := pool.ReserveAddr(addr); errReserve == nil {
+				held[addr] = true
+			}
+		default:
+			restored, errRestore := NewPoolFromSnapshot(pool.Snapshot(), WithBlockReclaim(0))
+			if errRestore != nil {
+				t.Fatalf("step %d: NewPoolFromSnapshot error: %v", step, errRestore)
+			}
+			pool = restored
+		}
+
+		if errVerify := pool.Verify(); errVerify != nil {
+			t.Fatalf("step %d: Verify error: %v", step, errVerify)
+		}
+		if n := pool.allocated(); n != uint64(len(held)) {
+			t.Fatalf("step %d: allocated() = %d; want %d", step, n, len(held))
+		}
+	}
+}
+
+// TestVerifyDetectsCorruption ensures Verify reports a block whose counters disagree with its bitmap
+func TestVerifyDetectsCorruption(t *testing.T) {
+	pool, err := NewPool("2001:db8::", 64, 120, 4)
+	if err != nil {
+		t.Fatalf("NewPool error: %v", err)
+	}
+	fillBlocks(t, pool, 3)
+
+	pool.blocks[0].freeCount++
+	if errVerify := pool.Verify(); !errors.Is(errVerify, ErrInconsistent) {
+		t.Errorf("Verify err = %v; want ErrInconsistent", errVerify)
+	}
+}